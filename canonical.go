@@ -0,0 +1,110 @@
+package ssh_config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// HostResolver resolves host names during hostname canonicalization (see
+// CanonicalizeHostname in the manpage for ssh_config).
+type HostResolver interface {
+	// LookupCanonicalName resolves the fully qualified host and returns its
+	// canonical name, i.e. the final name after following any CNAME
+	// records. It returns an error if host does not resolve.
+	LookupCanonicalName(host string) (string, error)
+}
+
+// netResolver is the default HostResolver, which uses the system resolver.
+type netResolver struct{}
+
+func (netResolver) LookupCanonicalName(host string) (string, error) {
+	cname, err := net.LookupCNAME(host)
+	if err == nil {
+		return cname, nil
+	}
+	if _, err2 := net.LookupHost(host); err2 != nil {
+		return "", err
+	}
+	return host, nil
+}
+
+// isNone reports whether v is unset or "none".
+func isNone(v string) bool {
+	return v == "" || strings.EqualFold(v, "none")
+}
+
+// matchPatternList reports whether s matches the comma-separated list of
+// patterns.
+func matchPatternList(list, s string) bool {
+	l, err := NewPatternList(list)
+	if err != nil {
+		return false
+	}
+	return l.Matches(s)
+}
+
+// canonicalize implements the hostname canonicalization of OpenSSH for host,
+// using the CanonicalDomains, CanonicalizeMaxDots, CanonicalizeFallbackLocal
+// and CanonicalizePermittedCNAMEs settings collected in r. It returns host
+// unchanged if it could not be canonicalized and CanonicalizeFallbackLocal is
+// enabled.
+func (r *resolver) canonicalize(host, mode string, res HostResolver) (string, error) {
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	// Don't canonicalize names that will be interpreted by a proxy unless
+	// explicitly requested.
+	direct := isNone(r.get("ProxyCommand")) && isNone(r.get("ProxyJump"))
+	if !direct && mode != "always" {
+		return host, nil
+	}
+
+	if strings.HasSuffix(host, ".") {
+		if cname, err := res.LookupCanonicalName(host); err == nil {
+			return r.followCNAME(strings.TrimSuffix(host, "."), cname), nil
+		}
+	} else {
+		maxDots, err := strconv.Atoi(r.get("CanonicalizeMaxDots"))
+		if err != nil {
+			return "", fmt.Errorf("ssh_config: invalid CanonicalizeMaxDots: %v", err)
+		}
+		// Don't canonicalize sufficiently qualified host names.
+		if strings.Count(host, ".") > maxDots {
+			return host, nil
+		}
+		for _, domain := range strings.Fields(r.get("CanonicalDomains")) {
+			if strings.EqualFold(domain, "none") {
+				break
+			}
+			fqdn := host + "." + domain
+			cname, err := res.LookupCanonicalName(fqdn + ".")
+			if err != nil {
+				continue
+			}
+			return r.followCNAME(fqdn, cname), nil
+		}
+	}
+
+	if strings.EqualFold(r.get("CanonicalizeFallbackLocal"), "no") {
+		return "", fmt.Errorf("ssh_config: could not resolve host %q", host)
+	}
+	return host, nil
+}
+
+// followCNAME returns cname if a rule of CanonicalizePermittedCNAMEs allows
+// name to be replaced by it, and name otherwise.
+func (r *resolver) followCNAME(name, cname string) string {
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	if cname == "" || cname == name {
+		return name
+	}
+	for _, rule := range strings.Fields(r.get("CanonicalizePermittedCNAMEs")) {
+		src, dst, ok := strings.Cut(strings.ToLower(rule), ":")
+		if ok && matchPatternList(src, name) && matchPatternList(dst, cname) {
+			return cname
+		}
+	}
+	return name
+}
@@ -0,0 +1,175 @@
+package ssh_config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeResolver maps fully qualified names to their canonical names.
+type fakeResolver map[string]string
+
+func (f fakeResolver) LookupCanonicalName(host string) (string, error) {
+	if cname, ok := f[host]; ok {
+		return cname, nil
+	}
+	return "", errors.New("no such host")
+}
+
+var testResolver = fakeResolver{
+	"db1.prod.corp.":    "db1.prod.corp.",
+	"web1.dev.corp.":    "web1.dev.corp.",
+	"db1.dev.corp.":     "db1.dev.corp.",
+	"assets.prod.corp.": "edge7.cdn.corp.",
+	"api.prod.corp.":    "api.elsewhere.net.",
+	"jump-1.prod.corp.": "jump-1.prod.corp.",
+}
+
+var canonicalTests = []struct {
+	alias    string
+	hostname string
+	port     string
+	user     string
+}{
+	{"db1", "db1.prod.corp", "2200", ""},
+	{"DB1", "db1.prod.corp", "2200", ""},
+	{"web1", "web1.dev.corp", "22", "developer"},
+	{"assets", "edge7.cdn.corp", "22", ""},
+	{"api", "api.prod.corp", "2200", ""},
+	{"unknown", "unknown", "22", ""},
+	{"db1.prod.corp", "db1.prod.corp", "2200", ""},
+	{"a.b.c", "a.b.c", "22", ""},
+	{"10.0.0.1", "10.0.0.1", "22", ""},
+	// proxied hosts are only canonicalized with CanonicalizeHostname always
+	{"jump-1", "jump-1", "22", ""},
+}
+
+func TestCanonicalize(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/canonical"),
+		systemConfigFinder: nullConfigFinder,
+		HostResolver:       testResolver,
+	}
+	for _, tt := range canonicalTests {
+		rc, err := us.Resolve(tt.alias, "")
+		if err != nil {
+			t.Errorf("Resolve(%q): %v", tt.alias, err)
+			continue
+		}
+		if got := rc.Get("HostName"); got != tt.hostname {
+			t.Errorf("Resolve(%q): expected HostName %q, got %q", tt.alias, tt.hostname, got)
+		}
+		if got := rc.Get("Port"); got != tt.port {
+			t.Errorf("Resolve(%q): expected Port %q, got %q", tt.alias, tt.port, got)
+		}
+		if got := rc.Get("User"); got != tt.user {
+			t.Errorf("Resolve(%q): expected User %q, got %q", tt.alias, tt.user, got)
+		}
+	}
+}
+
+func TestCanonicalizeFallbackLocal(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/canonical"),
+		systemConfigFinder: nullConfigFinder,
+		HostResolver:       testResolver,
+	}
+	_, err := us.Resolve("strict", "")
+	if err == nil {
+		t.Fatal("expected error resolving host with CanonicalizeFallbackLocal no, got nil")
+	}
+	if err.Error() != `ssh_config: could not resolve host "strict"` {
+		t.Errorf("wrong error: got %v", err)
+	}
+}
+
+// The values of CanonicalizeHostname and CanonicalizeFallbackLocal are
+// case-insensitive.
+var canonicalizeModeTests = []struct {
+	config   string
+	host     string
+	hostname string
+}{
+	{"CanonicalizeHostname No\n", "db1", "db1"},
+	{"CanonicalizeHostname YES\n", "db1", "db1.corp"},
+	{"CanonicalizeHostname YES\nProxyJump jump\n", "db1", "db1"},
+	{"CanonicalizeHostname Always\nProxyJump jump\n", "db1", "db1.corp"},
+	{"CanonicalizeHostname Yes\nCanonicalizeFallbackLocal No\n", "db2", ""},
+}
+
+func TestCanonicalizeModeCase(t *testing.T) {
+	dir := t.TempDir()
+	for i, tt := range canonicalizeModeTests {
+		name := filepath.Join(dir, fmt.Sprintf("config%d", i))
+		if err := os.WriteFile(name, []byte(tt.config+"CanonicalDomains corp\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		us := &UserSettings{
+			userConfigFinder:   testConfigFinder(name),
+			systemConfigFinder: nullConfigFinder,
+			HostResolver:       fakeResolver{"db1.corp.": "db1.corp."},
+		}
+		rc, err := us.Resolve(tt.host, "")
+		if tt.hostname == "" {
+			if err == nil {
+				t.Errorf("%q: expected error resolving %q, got nil", tt.config, tt.host)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: Resolve(%q): %v", tt.config, tt.host, err)
+			continue
+		}
+		if got := rc.Get("HostName"); got != tt.hostname {
+			t.Errorf("%q: expected HostName %q, got %q", tt.config, tt.hostname, got)
+		}
+	}
+}
+
+func TestMatchCanonicalGet(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/canonical"),
+		systemConfigFinder: nullConfigFinder,
+		HostResolver:       testResolver,
+	}
+	// GetStrict evaluates the final pass, like Resolve.
+	port, err := us.GetStrict("db1", "Port", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != "2200" {
+		t.Errorf("expected Port 2200, got %q", port)
+	}
+
+	// Config.Get evaluates a single pass, in which "Match canonical" never
+	// applies.
+	cfg, err := parseFile("testdata/canonical")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err = cfg.Get("Port", NewMatchContext("db1.prod.corp", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != "" {
+		t.Errorf("expected no Port, got %q", port)
+	}
+}
+
+func TestParseMatchCanonical(t *testing.T) {
+	for in, ok := range map[string]bool{
+		"Match canonical all\n":       true,
+		"Match canonical host a\n":    true,
+		"Match host a canonical\n":    true,
+		"Match host a all\n":          false,
+		"Match canonical final all\n": false,
+		"Match all canonical\n":       false,
+	} {
+		_, err := DecodeBytes([]byte(in))
+		if (err == nil) != ok {
+			t.Errorf("DecodeBytes(%q): got err %v, want ok %v", in, err, ok)
+		}
+	}
+}
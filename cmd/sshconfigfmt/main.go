@@ -0,0 +1,128 @@
+// Command sshconfigfmt formats ssh_config files.
+//
+// Usage:
+//
+//	sshconfigfmt [flags] [file ...]
+//
+// Without files, sshconfigfmt formats its standard input. By default, the
+// formatted configs are printed to standard output. The flags are:
+//
+//	-l
+//		List the files whose formatting differs from sshconfigfmt's, and
+//		exit with status 1 if there are any. Useful in pre-commit hooks.
+//	-w
+//		Write the result to the file instead of standard output.
+//	-indent string
+//		Indentation of the lines in Host and Match blocks (default two
+//		spaces). Use "\t" for a tab.
+//	-equals
+//		Separate keys from values with "=" rather than a space.
+//	-sort
+//		Sort the keys within each block.
+//
+// Include directives are formatted like other lines, but the files they include
+// are not read, and not formatted unless they are given as well. With -w, each
+// file is replaced atomically with ssh_config.WriteFile, which keeps its mode
+// and owner.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	ssh_config "github.com/alebeck/ssh_config"
+)
+
+var (
+	list     = flag.Bool("l", false, "list files whose formatting differs, and exit with status 1 if there are any")
+	write    = flag.Bool("w", false, "write result to the file instead of standard output")
+	indent   = flag.String("indent", "  ", `indentation of the lines in Host and Match blocks; use "\t" for a tab`)
+	equals   = flag.Bool("equals", false, `separate keys from values with "="`)
+	sortKeys = flag.Bool("sort", false, "sort the keys within each block")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: sshconfigfmt [flags] [file ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	opts := ssh_config.FormatOptions{
+		Indent:   strings.ReplaceAll(*indent, `\t`, "\t"),
+		Equals:   *equals,
+		SortKeys: *sortKeys,
+	}
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "sshconfigfmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(err)
+		}
+		changed, err := process("<standard input>", src, opts)
+		if err != nil {
+			fatal(err)
+		}
+		if changed && *list {
+			os.Exit(1)
+		}
+		return
+	}
+	status := 0
+	for _, name := range flag.Args() {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		changed, err := process(name, src, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		if changed && *list && status == 0 {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+// process formats the config src read from name, and reports whether its
+// formatting changed.
+func process(name string, src []byte, opts ssh_config.FormatOptions) (bool, error) {
+	cfg, err := ssh_config.DecodeOptions{Name: name, NoInclude: true}.DecodeBytes(src)
+	if err != nil {
+		return false, err
+	}
+	out := ssh_config.Format(cfg, opts)
+	changed := !bytes.Equal(src, out)
+	if *list && changed {
+		fmt.Println(name)
+	}
+	if *write && changed {
+		if err := ssh_config.WriteFile(name, out); err != nil {
+			return false, err
+		}
+	}
+	if !*list && !*write {
+		if _, err := os.Stdout.Write(out); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
@@ -28,15 +28,17 @@ package ssh_config
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"os"
 	osuser "os/user"
 	"path/filepath"
-	"regexp"
-	"runtime"
 	"slices"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -53,7 +55,39 @@ type configFinder func() string
 // UserSettings checks ~/.ssh and /etc/ssh for configuration files. The config
 // files are parsed and cached the first time Get() or GetStrict() is called.
 type UserSettings struct {
-	IgnoreErrors       bool
+	IgnoreErrors bool
+	// Executor runs the commands of "Match exec" criteria. If nil, commands
+	// are run by a ShellExecutor with default settings.
+	Executor CommandExecutor
+	// Interfaces lists the local addresses that "Match localnetwork"
+	// criteria are evaluated against. If nil, the addresses of the
+	// interfaces of this machine are used.
+	Interfaces InterfaceLister
+	// HostResolver resolves host names for hostname canonicalization. If
+	// nil, the system resolver is used.
+	HostResolver HostResolver
+	// Environment provides the environment variables and home directories
+	// used by ResolvedConfig.Expand. If nil, the environment of this
+	// process is used.
+	Environment Environment
+	// FS is the file system that configuration files, including the files
+	// of Include directives, are read from. Absolute paths are relative to
+	// the root of FS, so /etc/ssh/ssh_config is read as etc/ssh/ssh_config.
+	// If nil, the local file system is used.
+	FS fs.FS
+	// HomeDir is the home directory of the user whose configuration is
+	// read, used to find ~/.ssh/config, relative Include paths and "~". If
+	// empty, the home directory of the current user is used.
+	HomeDir string
+	// LocalUser is the name of the local user, as matched by "Match
+	// localuser" and expanded for the %u token. If empty, the name of the
+	// current user is used.
+	LocalUser string
+	// IndexHosts builds an index of the Host blocks of the configuration
+	// files when they are loaded, which speeds up lookups in files with
+	// many Host blocks. See Config.BuildIndex.
+	IndexHosts bool
+
 	customConfig       *Config
 	customConfigFinder configFinder
 	systemConfig       *Config
@@ -142,33 +176,6 @@ func handleModifiers(v, key string) string {
 	return strings.Join(out, ",")
 }
 
-func findVal(c *Config, key string, ctx *MatchContext) (string, error) {
-	if c == nil {
-		return "", nil
-	}
-	val, err := c.Get(key, ctx)
-	if err != nil || val == "" {
-		return "", err
-	}
-
-	// check for special symbols within algorithm specifications
-	if slices.Contains(modifiableKeys, key) {
-		val = handleModifiers(val, key)
-	}
-
-	if err := validate(key, val); err != nil {
-		return "", err
-	}
-	return val, nil
-}
-
-func findAll(c *Config, key string, ctx *MatchContext) ([]string, error) {
-	if c == nil {
-		return nil, nil
-	}
-	return c.GetAll(key, ctx)
-}
-
 // Get finds the first value for key within a declaration that matches the
 // alias. Get returns the empty string if no value was found, or if IgnoreErrors
 // is false and we could not parse the configuration file. Use GetStrict to
@@ -256,6 +263,12 @@ func (u *UserSettings) GetAll(alias, key, user string) []string {
 // default will be returned. For more information on default values and the way
 // patterns are matched, see the manpage for ssh_config.
 //
+// The configuration is evaluated like Resolve does, including the final pass
+// after hostname canonicalization or for "Match final" blocks, and the value
+// of HostName is expanded the same way. Each call evaluates the whole
+// configuration again, which may run "Match exec" commands and DNS lookups;
+// to look up several keys for the same host, call Resolve once instead.
+//
 // error will be non-nil if and only if a user's configuration file or the
 // system configuration file could not be parsed, and u.IgnoreErrors is false.
 func (u *UserSettings) GetStrict(alias, key, user string) (string, error) {
@@ -265,31 +278,28 @@ func (u *UserSettings) GetStrict(alias, key, user string) (string, error) {
 		return "", u.onceErr
 	}
 
-	ctx := NewMatchContext(alias, user)
-
-	// TODO this is getting repetitive
-	if u.customConfig != nil {
-		val, err := findVal(u.customConfig, key, ctx)
-		if err != nil || val != "" {
-			return val, err
-		}
+	r, err := u.evaluate(u.newMatchContext(alias, user))
+	if err != nil {
+		return "", err
+	}
+	if err := r.expandHostName(alias); err != nil {
+		return "", err
 	}
-	val, err := findVal(u.userConfig, key, ctx)
-	if err != nil || val != "" {
-		return val, err
+	vals := r.values[strings.ToLower(key)]
+	if len(vals) == 0 {
+		return Default(key), nil
 	}
-	val2, err2 := findVal(u.systemConfig, key, ctx)
-	if err2 != nil || val2 != "" {
-		return val2, err2
+	val := vals[0]
+	// check for special symbols within algorithm specifications
+	for _, mk := range modifiableKeys {
+		if strings.EqualFold(mk, key) {
+			val = handleModifiers(val, mk)
+		}
 	}
-
-	// No value found until now, so check final blocks
-	val3, err3 := ctx.matchFinal(key)
-	if err3 != nil || val3 != "" {
-		return val3, err3
+	if err := validate(key, val); err != nil {
+		return "", err
 	}
-
-	return Default(key), nil
+	return val, nil
 }
 
 // GetAllStrict retrieves zero or more directives for key for the given alias.
@@ -297,6 +307,8 @@ func (u *UserSettings) GetStrict(alias, key, user string) (string, error) {
 // default will be returned. For more information on default values and the way
 // patterns are matched, see the manpage for ssh_config.
 //
+// Like GetStrict, each call evaluates the whole configuration.
+//
 // The returned error will be non-nil if and only if a user's configuration file
 // or the system configuration file could not be parsed, and u.IgnoreErrors is
 // false.
@@ -307,34 +319,22 @@ func (u *UserSettings) GetAllStrict(alias, key, user string) ([]string, error) {
 		return nil, u.onceErr
 	}
 
-	ctx := NewMatchContext(alias, user)
-
-	if u.customConfig != nil {
-		val, err := findAll(u.customConfig, key, ctx)
-		if err != nil || val != nil {
-			return val, err
-		}
-	}
-	val, err := findAll(u.userConfig, key, ctx)
-	if err != nil || val != nil {
-		return val, err
+	r, err := u.evaluate(u.newMatchContext(alias, user))
+	if err != nil {
+		return nil, err
 	}
-	val2, err2 := findAll(u.systemConfig, key, ctx)
-	if err2 != nil || val2 != nil {
-		return val2, err2
+	if err := r.expandHostName(alias); err != nil {
+		return nil, err
 	}
-
-	// No value found until now, so check final blocks
-	val3, err3 := ctx.matchFinalAll(key)
-	if err3 != nil || val3 != nil {
-		return val3, err3
+	if vals := r.values[strings.ToLower(key)]; len(vals) > 0 {
+		return append([]string(nil), vals...), nil
 	}
 
 	if def := Default(key); def != "" {
 		return []string{def}, nil
 	}
 
-	if key == "IdentityFile" {
+	if strings.EqualFold(key, "IdentityFile") {
 		return defaultProtocol2Identities, nil
 	}
 
@@ -354,96 +354,280 @@ func (u *UserSettings) ConfigFinder(f func() string) {
 	u.customConfigFinder = f
 }
 
+// decoder returns a decoder for the user or system configuration file.
+func (u *UserSettings) decoder(system bool) *decoder {
+	return newDecoder(u.fileSystem(), system)
+}
+
+// fileSystem returns the fileSystem that u reads configuration files from.
+func (u *UserSettings) fileSystem() *fileSystem {
+	if u.FS == nil && u.HomeDir == "" && u.Environment == nil {
+		return osFileSystem
+	}
+	return &fileSystem{fsys: u.FS, home: u.HomeDir, env: u.Environment}
+}
+
+// environment returns the Environment used to expand values, which reports
+// u.HomeDir as the home directory of the current user if set.
+func (u *UserSettings) environment() Environment {
+	if u.HomeDir == "" {
+		return u.Environment
+	}
+	return u.fileSystem()
+}
+
 func (u *UserSettings) doLoadConfigs() {
 	u.loadConfigs.Do(func() {
 		var filename string
 		var err error
 		if u.customConfigFinder != nil {
 			filename = u.customConfigFinder()
-			u.customConfig, err = parseFile(filename)
+			u.customConfig, err = u.decoder(false).parse(filename, 0)
 			// IsNotExist should be returned because a user specified this
 			// function - not existing likely means they made an error
 			if err != nil {
 				u.onceErr = err
+			} else {
+				u.buildIndex(u.customConfig)
 			}
 			return
 		}
-		if u.userConfigFinder == nil {
-			filename = userConfigFinder()
-		} else {
+		if u.userConfigFinder != nil {
 			filename = u.userConfigFinder()
+		} else if u.HomeDir != "" {
+			filename = filepath.Join(u.HomeDir, ".ssh", "config")
+		} else {
+			filename = userConfigFinder()
 		}
-		u.userConfig, err = parseFile(filename)
+		u.userConfig, err = u.decoder(false).parse(filename, 0)
 		//lint:ignore S1002 I prefer it this way
-		if err != nil && os.IsNotExist(err) == false {
+		if err != nil && errors.Is(err, fs.ErrNotExist) == false {
 			u.onceErr = err
 			return
 		}
+		u.buildIndex(u.userConfig)
 		if u.systemConfigFinder == nil {
 			filename = systemConfigFinder()
 		} else {
 			filename = u.systemConfigFinder()
 		}
-		u.systemConfig, err = parseFile(filename)
+		u.systemConfig, err = u.decoder(true).parse(filename, 0)
 		//lint:ignore S1002 I prefer it this way
-		if err != nil && os.IsNotExist(err) == false {
+		if err != nil && errors.Is(err, fs.ErrNotExist) == false {
 			u.onceErr = err
 			return
 		}
+		u.buildIndex(u.systemConfig)
 	})
 }
 
+func (u *UserSettings) buildIndex(c *Config) {
+	if u.IndexHosts && c != nil {
+		c.BuildIndex()
+	}
+}
+
 func parseFile(filename string) (*Config, error) {
-	return parseWithDepth(filename, 0)
+	return newDecoder(osFileSystem, false).parse(filename, 0)
 }
 
-func parseWithDepth(filename string, depth uint8) (*Config, error) {
-	b, err := os.ReadFile(filename)
+// Decode reads r into a Config, or returns an error if r could not be parsed as
+// an SSH config file.
+func Decode(r io.Reader) (*Config, error) {
+	return DecodeOptions{}.Decode(r)
+}
+
+// DecodeBytes reads b into a Config, or returns an error if r could not be
+// parsed as an SSH config file.
+func DecodeBytes(b []byte) (*Config, error) {
+	return DecodeOptions{}.DecodeBytes(b)
+}
+
+// DecodeOptions control how a config file is decoded. The zero value decodes
+// a user configuration file, like Decode does.
+type DecodeOptions struct {
+	// Name is the name of the source, e.g. its file name, used in error
+	// messages.
+	Name string
+	// System is true if the source is a system configuration file such as
+	// /etc/ssh/ssh_config, rather than a user's ~/.ssh/config. Relative
+	// Include paths are relative to /etc/ssh in system configuration files,
+	// and to ~/.ssh otherwise. Included files inherit this setting.
+	System bool
+	// BaseDir, if set, is the directory relative Include paths are relative
+	// to, instead of ~/.ssh or /etc/ssh.
+	BaseDir string
+	// MaxDepth is the maximum number of nested Include directives. If zero
+	// or less, at most 5 levels are parsed.
+	MaxDepth int
+	// Strict makes it an error to use an unknown keyword, as ssh does,
+	// unless it matches a pattern of a preceding IgnoreUnknown directive, or
+	// to use an invalid value for a keyword such as Port.
+	Strict bool
+	// FS is the file system that included files are read from. Absolute
+	// paths are relative to the root of FS. If nil, the local file system
+	// is used.
+	FS fs.FS
+	// HomeDir is the home directory used to resolve ~/.ssh and "~" in
+	// Include paths. If empty, the home directory of the current user is
+	// used.
+	HomeDir string
+	// Tolerant makes the decoder skip lines and blocks with errors rather
+	// than stop at the first error. The Config is returned along with a
+	// ParseErrors that lists every error, in the order they were found.
+	// Skipped Host and Match blocks are kept in the Config, but never match.
+	Tolerant bool
+	// NoInclude parses Include directives without reading the files they
+	// include, e.g. to format or edit a single file. The Includes of the
+	// Config then have no configs.
+	NoInclude bool
+}
+
+// Decode reads r into a Config according to o.
+func (o DecodeOptions) Decode(r io.Reader) (*Config, error) {
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	return decodeBytes(b, isSystem(filename), depth)
+	return o.DecodeBytes(b)
 }
 
-func isSystem(filename string) bool {
-	// TODO: not sure this is the best way to detect a system repo
-	return strings.HasPrefix(filepath.Clean(filename), "/etc/ssh")
+// DecodeBytes reads b into a Config according to o.
+func (o DecodeOptions) DecodeBytes(b []byte) (*Config, error) {
+	return o.decoder().decode(b, o.Name)
 }
 
-// Decode reads r into a Config, or returns an error if r could not be parsed as
-// an SSH config file.
-func Decode(r io.Reader) (*Config, error) {
-	b, err := io.ReadAll(r)
+// DecodeFile reads the named file into a Config according to o. The file is
+// read from o.FS if set. If o.Name is empty, name is used in error messages.
+func (o DecodeOptions) DecodeFile(name string) (*Config, error) {
+	d := o.decoder()
+	b, err := d.fs.readFile(name)
 	if err != nil {
 		return nil, err
 	}
-	return decodeBytes(b, false, 0)
+	path := name
+	if o.Name != "" {
+		name = o.Name
+	}
+	c, err := d.decode(b, name)
+	if c != nil {
+		c.path = d.fs.localPath(path)
+	}
+	return c, err
 }
 
-// DecodeBytes reads b into a Config, or returns an error if r could not be
-// parsed as an SSH config file.
-func DecodeBytes(b []byte) (*Config, error) {
-	return decodeBytes(b, false, 0)
+func (o DecodeOptions) decoder() *decoder {
+	f := osFileSystem
+	if o.FS != nil || o.HomeDir != "" {
+		f = &fileSystem{fsys: o.FS, home: o.HomeDir}
+	}
+	d := newDecoder(f, o.System)
+	d.baseDir = o.BaseDir
+	if o.MaxDepth > 0 {
+		d.maxDepth = o.MaxDepth
+	}
+	d.strict = o.Strict
+	d.tolerant = o.Tolerant
+	d.noInclude = o.NoInclude
+	return d
+}
+
+// decoder decodes a config file and the files it includes.
+type decoder struct {
+	fs        *fileSystem
+	system    bool
+	baseDir   string
+	maxDepth  int
+	strict    bool
+	tolerant  bool
+	noInclude bool
+	// patterns of the IgnoreUnknown directives seen so far
+	ignoreUnknown []*PatternList
+	// Include directives through which the current file is read
+	chain []IncludeStep
+	// errors found so far in tolerant mode
+	diagnostics []*ParseError
+}
+
+func newDecoder(f *fileSystem, system bool) *decoder {
+	return &decoder{fs: f, system: system, maxDepth: maxRecurseDepth}
+}
+
+// parse reads and decodes the named file.
+func (d *decoder) parse(filename string, depth uint8) (*Config, error) {
+	b, err := d.fs.readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.decodeBytes(b, filename, depth)
+	if c != nil {
+		c.path = d.fs.localPath(filename)
+	}
+	return c, err
 }
 
-func decodeBytes(b []byte, system bool, depth uint8) (c *Config, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			if e, ok := r.(error); ok && e == ErrDepthExceeded {
-				err = e
-				return
-			}
-			err = errors.New(r.(string))
-		}
-	}()
+func (d *decoder) decodeBytes(b []byte, name string, depth uint8) (*Config, error) {
+	sum := sha256.Sum256(b)
+	bom := bytes.HasPrefix(b, []byte(byteOrderMark))
+	if bom {
+		b = b[len(byteOrderMark):]
+	}
+	c, err := parseSSH(b, d, name, depth)
+	if c != nil {
+		c.bom = bom
+		c.sum = sum
+	}
+	return c, err
+}
 
-	c = parseSSH(lexSSH(b), system, depth)
+// byteOrderMark is skipped at the start of a file, and printed again by
+// Config.String.
+const byteOrderMark = "\ufeff"
+
+// decode decodes the top-level file b. In tolerant mode, the errors of b and
+// the files it includes are returned as ParseErrors along with the Config.
+func (d *decoder) decode(b []byte, name string) (*Config, error) {
+	c, err := d.decodeBytes(b, name, 0)
+	if err == nil && len(d.diagnostics) > 0 {
+		err = ParseErrors(d.diagnostics)
+	}
 	return c, err
 }
 
+// includePath returns the path of the files included by an Include directive
+// with the given argument. Relative paths are relative to d.baseDir if set,
+// and otherwise to ~/.ssh for user configurations and /etc/ssh for system
+// configurations. A leading "~" is expanded to the home directory of the
+// current or the named user.
+func (d *decoder) includePath(arg string) (string, error) {
+	switch {
+	case filepath.IsAbs(arg):
+		return arg, nil
+	case strings.HasPrefix(arg, "~"):
+		return expandTilde("Include", arg, d.fs)
+	case d.baseDir != "":
+		return filepath.Join(d.baseDir, arg), nil
+	case d.system:
+		return filepath.Join("/etc/ssh", arg), nil
+	default:
+		home, err := d.fs.HomeDir("")
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".ssh", arg), nil
+	}
+}
+
+// ignored reports whether the unknown keyword key should be ignored.
+func (d *decoder) ignored(key string) bool {
+	for _, l := range d.ignoreUnknown {
+		if l.Matches(key) {
+			return true
+		}
+	}
+	return false
+}
+
 // Config represents an SSH config file.
 type Config struct {
 	// A list of blocks to match against. The file begins with an implicit
@@ -451,6 +635,18 @@ type Config struct {
 	Blocks   []Block
 	depth    uint8
 	position Position
+	// index is built by BuildIndex.
+	index *hostIndex
+	// bom is true if the file starts with a UTF-8 byte order mark.
+	bom bool
+	// style is the formatting of lines added by the editing methods.
+	style *style
+	// path is the file c was read from on the local file system, if any.
+	path string
+	// sum is the SHA-256 hash of the file as it was read or last saved,
+	// which tells SaveAll whether c or the file changed since. The contents
+	// themselves are not kept, to save memory with large Include trees.
+	sum [sha256.Size]byte
 }
 
 // MatchContext holds information about previously matched values,
@@ -464,8 +660,52 @@ type MatchContext struct {
 	LocalUser string
 	// Original Host, a.k.a. alias
 	OriginalHost string
-	// Final blocks to parse after matching
+	// Remote port, if one has been configured
+	Port string
+	// Tag is the tag set by the first Tag directive that applies, or by the
+	// caller (like "ssh -P"), which takes precedence over the configuration.
+	// It is matched by "Match tagged" criteria.
+	Tag string
+	// Version is the version of the ssh client, e.g. "OpenSSH_9.8", matched
+	// by "Match version" criteria.
+	Version string
+	// SessionType is the type of session that will be requested: "shell",
+	// "exec", "subsystem" or "none". It is matched by "Match sessiontype"
+	// criteria. If empty, it is "exec" if Command is set and "shell"
+	// otherwise.
+	SessionType string
+	// Command is the remote command or subsystem that will be requested,
+	// matched by "Match command" criteria.
+	Command string
+	// FinalPass is true while the configuration is evaluated a second time
+	// after hostname canonicalization. "Match canonical" criteria are only
+	// satisfied in the final pass.
+	FinalPass bool
+	// FinalBlocks is no longer used.
+	//
+	// Deprecated: "Match final" blocks are satisfied in the final pass only,
+	// see FinalPass.
 	FinalBlocks []Block
+	// Executor runs the commands of "Match exec" criteria. If nil, commands
+	// are run by a ShellExecutor with default settings.
+	Executor CommandExecutor
+	// Interfaces lists the local addresses that "Match localnetwork"
+	// criteria are evaluated against. If nil, the addresses of the
+	// interfaces of this machine are used.
+	Interfaces InterfaceLister
+	// Environment provides the home directory of the local user, which is
+	// expanded for the %d token in the commands of "Match exec" criteria.
+	// If nil, the home directory of the current user is used.
+	Environment Environment
+
+	// HostKeyAlias and ProxyJump, if configured, for expanding the
+	// commands of "Match exec" criteria
+	hostKeyAlias string
+	proxyJump    string
+	// results of "Match exec" commands, keyed by the expanded command
+	execResults map[string]bool
+	// addresses returned by Interfaces
+	localAddrs []net.IP
 }
 
 func NewMatchContext(alias, user string) *MatchContext {
@@ -477,33 +717,25 @@ func NewMatchContext(alias, user string) *MatchContext {
 	}
 }
 
-func (ctx *MatchContext) matchFinal(key string) (string, error) {
-	for _, block := range ctx.FinalBlocks {
-		if !block.Matches(ctx) {
-			continue
-		}
-		found, err := handleBlock(block, key, nil)
-		if err != nil {
-			return "", err
-		}
-		if found != "" {
-			return found, nil
-		}
+func (u *UserSettings) newMatchContext(alias, user string) *MatchContext {
+	ctx := NewMatchContext(alias, user)
+	if u.LocalUser != "" {
+		ctx.LocalUser = u.LocalUser
 	}
-	return "", nil
+	ctx.Executor = u.Executor
+	ctx.Interfaces = u.Interfaces
+	ctx.Environment = u.environment()
+	return ctx
 }
 
-func (ctx *MatchContext) matchFinalAll(key string) (all []string, err error) {
-	for _, block := range ctx.FinalBlocks {
-		if !block.Matches(ctx) {
-			continue
-		}
-		all, err = handleBlockAll(block, all, key, nil)
-		if err != nil {
-			return nil, err
-		}
+func (ctx *MatchContext) sessionType() string {
+	if ctx.SessionType != "" {
+		return ctx.SessionType
 	}
-	return all, nil
+	if ctx.Command != "" {
+		return "exec"
+	}
+	return "shell"
 }
 
 func handleBlock(block Block, key string, ctx *MatchContext) (string, error) {
@@ -529,6 +761,16 @@ func handleBlock(block Block, key string, ctx *MatchContext) (string, error) {
 					ctx.User = t.Value
 				case "hostname":
 					ctx.Host = t.Value
+				case "port":
+					ctx.Port = t.Value
+				case "tag":
+					if ctx.Tag == "" {
+						ctx.Tag = t.Value
+					}
+				case "hostkeyalias":
+					ctx.hostKeyAlias = t.Value
+				case "proxyjump":
+					ctx.proxyJump = t.Value
 				}
 			}
 		case *Include:
@@ -566,6 +808,16 @@ func handleBlockAll(block Block, all []string, key string, ctx *MatchContext) ([
 					ctx.User = t.Value
 				case "hostname":
 					ctx.Host = t.Value
+				case "port":
+					ctx.Port = t.Value
+				case "tag":
+					if ctx.Tag == "" {
+						ctx.Tag = t.Value
+					}
+				case "hostkeyalias":
+					ctx.hostKeyAlias = t.Value
+				case "proxyjump":
+					ctx.proxyJump = t.Value
 				}
 			}
 		case *Include:
@@ -585,12 +837,15 @@ func handleBlockAll(block Block, all []string, key string, ctx *MatchContext) ([
 // Config contains an invalid conditional Include value.
 //
 // The match for key is case insensitive.
+//
+// Get evaluates c once: "Match final" and "Match canonical" blocks are only
+// satisfied if ctx.FinalPass is set. UserSettings.GetStrict and Resolve
+// perform the complete evaluation, including the final pass.
 func (c *Config) Get(key string, ctx *MatchContext) (string, error) {
-	for _, block := range c.Blocks {
-		if block.IsFinal() {
-			ctx.FinalBlocks = append(ctx.FinalBlocks, block)
-			continue
-		}
+	// In the final pass, Host blocks match ctx.Host, which HostName
+	// directives change as blocks are applied.
+	for it := c.blocksFor(ctx, !ctx.FinalPass); it.next(); {
+		block := c.Blocks[it.i]
 		if !block.Matches(ctx) {
 			continue
 		}
@@ -607,15 +862,12 @@ func (c *Config) Get(key string, ctx *MatchContext) (string, error) {
 }
 
 // GetAll returns all values in the configuration that match the alias and
-// contains key, or nil if none are present.
+// contains key, or nil if none are present. Like Get, GetAll evaluates c once.
 func (c *Config) GetAll(key string, ctx *MatchContext) ([]string, error) {
 	all := []string(nil)
 	var err error
-	for _, block := range c.Blocks {
-		if block.IsFinal() {
-			ctx.FinalBlocks = append(ctx.FinalBlocks, block)
-			continue
-		}
+	for it := c.blocksFor(ctx, !ctx.FinalPass); it.next(); {
+		block := c.Blocks[it.i]
 		if !block.Matches(ctx) {
 			continue
 		}
@@ -638,7 +890,15 @@ func (c Config) MarshalText() ([]byte, error) {
 
 func marshal(c Config) *bytes.Buffer {
 	var buf bytes.Buffer
+	if c.bom {
+		buf.WriteString(byteOrderMark)
+	}
+	start := buf.Len()
 	for i := range c.Blocks {
+		// Only the last line of the file may be unterminated.
+		if n := buf.Len(); n > start && buf.Bytes()[n-1] != '\n' {
+			buf.WriteByte('\n')
+		}
 		buf.WriteString(c.Blocks[i].String())
 	}
 	return &buf
@@ -647,9 +907,10 @@ func marshal(c Config) *bytes.Buffer {
 // Pattern is a pattern in a Host declaration. Patterns are read-only values;
 // create a new one with NewPattern().
 type Pattern struct {
-	str   string // Its appearance in the file, not the value that gets compiled.
-	regex *regexp.Regexp
-	not   bool // True if this is a negated match
+	str  string // Its appearance in the file, not the value that gets matched.
+	glob string // The pattern to match, without the leading "!"
+	fold bool   // True if ASCII letters match regardless of case
+	not  bool   // True if this is a negated match
 }
 
 // String prints the string representation of the pattern.
@@ -657,15 +918,9 @@ func (p Pattern) String() string {
 	return p.str
 }
 
-// Copied from regexp.go with * and ? removed.
-var specialBytes = []byte(`\.+()|[]{}^$`)
-
-func special(b byte) bool {
-	return bytes.IndexByte(specialBytes, b) >= 0
-}
-
 // NewPattern creates a new Pattern for matching hosts. NewPattern("*") creates
-// a Pattern that matches all hosts.
+// a Pattern that matches all hosts. As in OpenSSH, patterns are matched byte
+// by byte, and ASCII letters in host names are matched case-insensitively.
 //
 // From the manpage, a pattern consists of zero or more non-whitespace
 // characters, `*' (a wildcard that matches zero or more characters), or `?' (a
@@ -679,37 +934,78 @@ func special(b byte) bool {
 //
 //	Host 192.168.0.?
 func NewPattern(s string) (*Pattern, error) {
+	return newPattern(s, true)
+}
+
+// newPattern creates a new Pattern. If fold is true, the pattern matches
+// case-insensitively.
+func newPattern(s string, fold bool) (*Pattern, error) {
 	if s == "" {
 		return nil, errors.New("ssh_config: empty pattern")
 	}
+	str := s
 	negated := false
 	if s[0] == '!' {
 		negated = true
 		s = s[1:]
 	}
-	var buf bytes.Buffer
-	buf.WriteByte('^')
-	for i := 0; i < len(s); i++ {
-		// A byte loop is correct because all metacharacters are ASCII.
-		switch b := s[i]; b {
-		case '*':
-			buf.WriteString(".*")
-		case '?':
-			buf.WriteString(".?")
-		default:
-			// borrowing from QuoteMeta here.
-			if special(b) {
-				buf.WriteByte('\\')
-			}
-			buf.WriteByte(b)
+	return &Pattern{str: str, glob: s, fold: fold, not: negated}, nil
+}
+
+// matches reports whether s matches p, ignoring whether p is negated.
+func (p *Pattern) matches(s string) bool {
+	return matchGlob(p.glob, s, p.fold)
+}
+
+// PatternList is a comma-separated list of patterns, as used by the criteria
+// of Match directives, e.g. "*.example.com,!bastion.example.com". Pattern
+// lists are read-only values; create a new one with NewPatternList().
+type PatternList struct {
+	str      string
+	patterns []*Pattern
+}
+
+// NewPatternList creates a new PatternList for matching hosts from the
+// comma-separated list of patterns in s. Host names are matched
+// case-insensitively.
+func NewPatternList(s string) (*PatternList, error) {
+	return newPatternList(s, true)
+}
+
+// newPatternList creates a new PatternList. If fold is true, the patterns
+// match case-insensitively.
+func newPatternList(s string, fold bool) (*PatternList, error) {
+	strs := strings.Split(s, ",")
+	patterns := make([]*Pattern, len(strs))
+	for i := range strs {
+		pat, err := newPattern(strs[i], fold)
+		if err != nil {
+			return nil, err
 		}
+		patterns[i] = pat
 	}
-	buf.WriteByte('$')
-	r, err := regexp.Compile(buf.String())
-	if err != nil {
-		return nil, err
+	return &PatternList{str: s, patterns: patterns}, nil
+}
+
+// String prints the pattern list as it appeared in the file.
+func (l *PatternList) String() string {
+	return l.str
+}
+
+// Matches reports whether s matches the pattern list. As in OpenSSH, s
+// matches if it matches at least one of the patterns and none of the negated
+// patterns; a matching negated pattern causes the whole list not to match.
+func (l *PatternList) Matches(s string) bool {
+	found := false
+	for _, p := range l.patterns {
+		if p.matches(s) {
+			if p.not {
+				return false
+			}
+			found = true
+		}
 	}
-	return &Pattern{str: s, regex: r, not: negated}, nil
+	return found
 }
 
 // Block describes either a Host or Match directive, which must
@@ -735,12 +1031,34 @@ type BlockData struct {
 	// Whitespace if any between the Host declaration and a trailing comment.
 	spaceBeforeComment string
 
-	hasEquals    bool
-	leadingSpace int // TODO: handle spaces vs tabs here.
+	hasEquals bool
+	keyword   string // The Host or Match keyword as written in the file
+	indent    string // Whitespace before the keyword
+	line      sourceLine
 	// The file starts with an implicit "Host *" declaration.
 	implicit bool
 	// Final indicates whether this match block is final
 	Final bool
+	// The Host or Match line could not be parsed, and is kept as written.
+	invalid bool
+	value   string
+	// style is the formatting of lines added by Set and Append.
+	style *style
+}
+
+// keywordOr returns the keyword of the block as written in the file, or def
+// if the block was not read from a file.
+func (b *BlockData) keywordOr(def string) string {
+	if b == nil || b.keyword == "" {
+		return def
+	}
+	return b.keyword
+}
+
+// isInvalid reports whether the block was kept despite an error in its Host or
+// Match line, in tolerant mode.
+func (b *BlockData) isInvalid() bool {
+	return b != nil && b.invalid
 }
 
 // Host describes a Host directive and the keywords that follow it.
@@ -762,13 +1080,21 @@ func (h *Host) IsFinal() bool {
 	return h.Final
 }
 
-// Matches returns true if the Host matches for the given alias. For
-// a description of the rules that provide a match, see the manpage for
-// ssh_config.
+// Matches returns true if the Host matches for the given alias. In the final
+// pass, after hostname canonicalization, the patterns are matched against the
+// canonical host name instead. For a description of the rules that provide a
+// match, see the manpage for ssh_config.
 func (h *Host) Matches(ctx *MatchContext) bool {
+	if h.isInvalid() {
+		return false
+	}
+	host := ctx.OriginalHost
+	if ctx.FinalPass {
+		host = ctx.Host
+	}
 	found := false
 	for i := range h.Patterns {
-		if h.Patterns[i].regex.MatchString(ctx.OriginalHost) {
+		if h.Patterns[i].matches(host) {
 			if h.Patterns[i].not {
 				// Negated match. "A pattern entry may be negated by prefixing
 				// it with an exclamation mark (`!'). If a negated entry is
@@ -789,16 +1115,26 @@ func (h *Host) Matches(ctx *MatchContext) bool {
 func (h *Host) String() string {
 	var buf strings.Builder
 	//lint:ignore S1002 I prefer to write it this way
-	if h.implicit == false {
-		buf.WriteString(strings.Repeat(" ", int(h.leadingSpace)))
-		buf.WriteString("Host")
+	if h.implicit == false && h.line.unchanged(h.fields()) {
+		buf.WriteString(h.line.text)
+		buf.WriteString(headerEnding(h.line, h.Nodes))
+	} else if h.implicit == false {
+		buf.WriteString(h.indent)
+		buf.WriteString(h.keywordOr("Host"))
 		if h.hasEquals {
 			buf.WriteString(" = ")
 		} else {
 			buf.WriteString(" ")
 		}
+		if h.isInvalid() {
+			buf.WriteString(h.value)
+		}
 		for i, pat := range h.Patterns {
-			buf.WriteString(pat.String())
+			str := pat.String()
+			if strings.ContainsAny(str, " \t") {
+				str = `"` + str + `"`
+			}
+			buf.WriteString(str)
 			if i < len(h.Patterns)-1 {
 				buf.WriteString(" ")
 			}
@@ -808,21 +1144,133 @@ func (h *Host) String() string {
 			buf.WriteByte('#')
 			buf.WriteString(h.EOLComment)
 		}
-		buf.WriteByte('\n')
+		buf.WriteString(headerEnding(h.line, h.Nodes))
+	}
+	writeNodes(&buf, h.Nodes)
+	return buf.String()
+}
+
+// fields returns the fields of h that are printed in the Host line.
+func (h *Host) fields() string {
+	var buf strings.Builder
+	if h.isInvalid() {
+		buf.WriteString(h.value)
 	}
-	for i := range h.Nodes {
-		buf.WriteString(h.Nodes[i].String())
-		buf.WriteByte('\n')
+	for _, pat := range h.Patterns {
+		buf.WriteString(pat.String())
+		buf.WriteByte(0)
 	}
+	buf.WriteString(h.EOLComment)
 	return buf.String()
 }
 
+// headerEnding returns the terminator of the Host or Match line l, followed
+// by nodes.
+func headerEnding(l sourceLine, nodes []Node) string {
+	if eol := l.lineEnding(); eol != "" || len(nodes) == 0 {
+		return eol
+	}
+	return "\n"
+}
+
+// writeNodes writes nodes to buf, each followed by the line terminator it
+// was read with. Nodes other than the last are always terminated.
+func writeNodes(buf *strings.Builder, nodes []Node) {
+	for i, node := range nodes {
+		buf.WriteString(node.String())
+		eol := "\n"
+		switch t := node.(type) {
+		case *KV:
+			eol = t.line.lineEnding()
+		case *Empty:
+			eol = t.line.lineEnding()
+		case *Include:
+			eol = t.line.lineEnding()
+		}
+		if eol == "" && i < len(nodes)-1 {
+			eol = "\n"
+		}
+		buf.WriteString(eol)
+	}
+}
+
+// Match describes a Match directive and the keywords that follow it.
 type Match struct {
-	// Patterns is a map of key -> Pattern entries, e.g. "Address" -> Pattern("127.0.0.*")
-	Patterns map[string]*Pattern
+	// Criteria lists the conditions of the Match directive in the order they
+	// appear in the file. All of them have to be satisfied for the block to
+	// apply. The "all" keyword is the criterion "all", which is always
+	// satisfied.
+	Criteria []*MatchCriterion
 	*BlockData
 }
 
+// MatchCriterion is a single condition of a Match directive, for example
+// "host *.example.com" or `!exec "test -f ~/.vpn-up"`.
+type MatchCriterion struct {
+	// Keyword is the lower-cased name of the criterion, e.g. "host".
+	Keyword string
+	// Negated is true if the criterion was prefixed with "!", in which case
+	// it is satisfied if the condition is not.
+	Negated bool
+	// Arg is the argument of the criterion, with any quotes removed. It is
+	// empty for criteria without an argument such as "canonical".
+	Arg string
+	// Patterns is the compiled Arg for criteria that match against patterns.
+	// It is nil for criteria such as "exec".
+	Patterns *PatternList
+	// Networks is the parsed Arg of a "localnetwork" criterion.
+	Networks []*net.IPNet
+
+	// spelling is the keyword as written in the file, and rawArg the
+	// argument, including any quotes.
+	spelling string
+	rawArg   string
+}
+
+// String prints c as it appears in a Match directive. The keyword and the
+// argument are printed as they were written in the file unless they have
+// been changed since; a changed argument is quoted if necessary.
+func (c *MatchCriterion) String() string {
+	var buf strings.Builder
+	if c.Negated {
+		buf.WriteByte('!')
+	}
+	if strings.EqualFold(c.spelling, c.Keyword) {
+		buf.WriteString(c.spelling)
+	} else {
+		buf.WriteString(c.Keyword)
+	}
+	switch c.Keyword {
+	case "all", "canonical", "final":
+		return buf.String()
+	}
+	buf.WriteByte(' ')
+	if args, err := splitArgs(c.rawArg); err == nil && len(args) == 1 && args[0] == c.Arg {
+		buf.WriteString(c.rawArg)
+	} else {
+		buf.WriteString(quoteArg(c.Arg))
+	}
+	return buf.String()
+}
+
+// quoteArg quotes s, if necessary, so that splitArgs reads it as a single
+// argument.
+func quoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
 func (m *Match) GetNodes() []Node {
 	return m.Nodes
 }
@@ -831,32 +1279,116 @@ func (m *Match) SetNodes(nodes []Node) {
 	m.Nodes = nodes
 }
 
+// Matches returns true if all criteria of the Match directive are satisfied
+// by ctx. Criteria are evaluated in order, and evaluation stops at the first
+// criterion that is not satisfied, so commands of later "exec" criteria are
+// not run.
 func (m *Match) Matches(ctx *MatchContext) bool {
-	// All patterns have to match to make the block apply.
-	// If a context value is empty, the pattern is considered no match.
-	for k, p := range m.Patterns {
-		var comp string
-		switch k {
-		case "host":
-			comp = ctx.Host
-		case "user":
-			comp = ctx.User
-		case "originalhost":
-			comp = ctx.OriginalHost
-		case "localuser":
-			comp = ctx.LocalUser
-		default:
-			panic("unknown Match directive key: " + k)
-		}
-		if comp == "" || p.not == p.regex.MatchString(comp) {
+	if m.isInvalid() {
+		return false
+	}
+	for _, c := range m.Criteria {
+		if !c.matches(ctx) {
 			return false
 		}
 	}
 	return true
 }
 
+func (c *MatchCriterion) matches(ctx *MatchContext) bool {
+	return c.Negated != c.evaluate(ctx)
+}
+
+func (c *MatchCriterion) evaluate(ctx *MatchContext) bool {
+	var comp string
+	// Unless noted otherwise, an empty context value is considered no match.
+	matchEmpty := false
+	switch c.Keyword {
+	case "all":
+		return true
+	case "canonical", "final":
+		return ctx.FinalPass
+	case "exec":
+		return ctx.runExec(c.Arg)
+	case "localnetwork":
+		return ctx.matchLocalNetwork(c.Networks)
+	case "host":
+		comp = ctx.Host
+	case "user":
+		comp = ctx.User
+	case "originalhost":
+		comp = ctx.OriginalHost
+	case "localuser":
+		comp = ctx.LocalUser
+	case "tagged":
+		comp, matchEmpty = ctx.Tag, true
+	case "version":
+		comp, matchEmpty = ctx.Version, true
+	case "sessiontype":
+		comp = ctx.sessionType()
+	case "command":
+		comp, matchEmpty = ctx.Command, true
+	default:
+		panic("unknown Match directive key: " + c.Keyword)
+	}
+	if comp == "" && !matchEmpty {
+		return false
+	}
+	return c.Patterns.Matches(comp)
+}
+
+// String prints m as it would appear in a config file. If the Match line has
+// not been changed since it was read, it is printed as it was read; otherwise
+// the criteria are printed in order, separated by single spaces.
 func (m *Match) String() string {
-	panic("Match does not support String() serialization for now")
+	var buf strings.Builder
+	if m.line.unchanged(m.fields()) {
+		buf.WriteString(m.line.text)
+	} else {
+		buf.WriteString(m.indent)
+		buf.WriteString(m.keywordOr("Match"))
+		if m.hasEquals {
+			buf.WriteString(" = ")
+		} else {
+			buf.WriteString(" ")
+		}
+		if m.isInvalid() {
+			buf.WriteString(m.value)
+		}
+		for i, c := range m.Criteria {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(c.String())
+		}
+		buf.WriteString(m.spaceBeforeComment)
+		if m.EOLComment != "" {
+			buf.WriteByte('#')
+			buf.WriteString(m.EOLComment)
+		}
+	}
+	buf.WriteString(headerEnding(m.line, m.Nodes))
+	writeNodes(&buf, m.Nodes)
+	return buf.String()
+}
+
+// fields returns the fields of m that are printed in the Match line.
+func (m *Match) fields() string {
+	var buf strings.Builder
+	if m.isInvalid() {
+		buf.WriteString(m.value)
+	}
+	for _, c := range m.Criteria {
+		if c.Negated {
+			buf.WriteByte('!')
+		}
+		buf.WriteString(c.Keyword)
+		buf.WriteByte(0)
+		buf.WriteString(c.Arg)
+		buf.WriteByte(0)
+	}
+	buf.WriteString(m.EOLComment)
+	return buf.String()
 }
 
 func (m *Match) IsFinal() bool {
@@ -878,8 +1410,9 @@ type KV struct {
 	spaceAfterValue string
 	Comment         string
 	hasEquals       bool
-	leadingSpace    int // Space before the key. TODO handle spaces vs tabs.
+	indent          string // Whitespace before the key
 	position        Position
+	line            sourceLine
 }
 
 // Pos returns k's Position.
@@ -887,27 +1420,36 @@ func (k *KV) Pos() Position {
 	return k.position
 }
 
-// String prints k as it was parsed in the config file.
+// String prints k as it was parsed in the config file. If k has been changed
+// since, the line is printed with the original indentation.
 func (k *KV) String() string {
 	if k == nil {
 		return ""
 	}
+	if k.line.unchanged(k.fields()) {
+		return k.line.text
+	}
 	equals := " "
 	if k.hasEquals {
 		equals = " = "
 	}
-	line := strings.Repeat(" ", int(k.leadingSpace)) + k.Key + equals + k.Value + k.spaceAfterValue
+	line := k.indent + k.Key + equals + k.Value + k.spaceAfterValue
 	if k.Comment != "" {
 		line += "#" + k.Comment
 	}
 	return line
 }
 
+func (k *KV) fields() string {
+	return k.Key + "\x00" + k.Value + "\x00" + k.Comment
+}
+
 // Empty is a line in the config file that contains only whitespace or comments.
 type Empty struct {
-	Comment      string
-	leadingSpace int // TODO handle spaces vs tabs.
-	position     Position
+	Comment  string
+	indent   string // Whitespace before the comment
+	position Position
+	line     sourceLine
 }
 
 // Pos returns e's Position.
@@ -920,15 +1462,27 @@ func (e *Empty) String() string {
 	if e == nil {
 		return ""
 	}
+	if e.line.unchanged(e.fields()) {
+		return e.line.text
+	}
 	if e.Comment == "" {
 		return ""
 	}
-	return fmt.Sprintf("%s#%s", strings.Repeat(" ", int(e.leadingSpace)), e.Comment)
+	return e.indent + "#" + e.Comment
+}
+
+func (e *Empty) fields() string {
+	return e.Comment
 }
 
 // Include holds the result of an Include directive, including the config files
 // that have been parsed as part of that directive. At most 5 levels of Include
 // statements will be parsed.
+//
+// As in OpenSSH, an included file only applies if the block containing the
+// Include matches. Lines before the first Host or Match in the included file
+// belong to that block; Host and Match blocks in the included file end at the
+// end of that file, and the including block continues after the Include.
 type Include struct {
 	// Comment is the contents of any comment at the end of the Include
 	// statement.
@@ -937,81 +1491,80 @@ type Include struct {
 	directives []string
 
 	mu sync.Mutex
-	// 1:1 mapping between matches and keys in files array; matches preserves
-	// ordering
+	// the included files in the order they are evaluated; each is a key in
+	// files
 	matches []string
 	// actual filenames are listed here
-	files        map[string]*Config
-	leadingSpace int
-	position     Position
-	depth        uint8
-	hasEquals    bool
+	files           map[string]*Config
+	indent          string
+	spaceAfterValue string
+	position        Position
+	depth           uint8
+	hasEquals       bool
+	line            sourceLine
 }
 
 const maxRecurseDepth = 5
 
-// ErrDepthExceeded is returned if too many Include directives are parsed.
-// Usually this indicates a recursive loop (an Include directive pointing to the
-// file it contains).
+// ErrDepthExceeded is the cause of the ParseError returned if too many Include
+// directives are parsed. Usually this indicates a recursive loop (an Include
+// directive pointing to the file it contains).
 var ErrDepthExceeded = errors.New("ssh_config: max recurse depth exceeded")
 
-func removeDups(arr []string) []string {
-	// Use map to record duplicates as we find them.
-	encountered := make(map[string]bool, len(arr))
-	result := make([]string, 0)
-
-	for v := range arr {
-		//lint:ignore S1002 I prefer it this way
-		if encountered[arr[v]] == false {
-			encountered[arr[v]] = true
-			result = append(result, arr[v])
-		}
-	}
-	return result
-}
-
 // NewInclude creates a new Include with a list of file globs to include.
 // Configuration files are parsed greedily (e.g. as soon as this function runs).
 // Any error encountered while parsing nested configuration files will be
 // returned.
 func NewInclude(directives []string, hasEquals bool, pos Position, comment string, system bool, depth uint8) (*Include, error) {
-	if depth > maxRecurseDepth {
+	return newInclude(directives, hasEquals, pos, comment, newDecoder(osFileSystem, system), depth)
+}
+
+func newInclude(directives []string, hasEquals bool, pos Position, comment string, d *decoder, depth uint8) (*Include, error) {
+	if int(depth) > d.maxDepth {
 		return nil, ErrDepthExceeded
 	}
 	inc := &Include{
-		Comment:      comment,
-		directives:   directives,
-		files:        make(map[string]*Config),
-		position:     pos,
-		leadingSpace: pos.Col - 1,
-		depth:        depth,
-		hasEquals:    hasEquals,
+		Comment:    comment,
+		directives: directives,
+		files:      make(map[string]*Config),
+		position:   pos,
+		indent:     strings.Repeat(" ", pos.Col-1),
+		depth:      depth,
+		hasEquals:  hasEquals,
 	}
 	// no need for inc.mu.Lock() since nothing else can access this inc
-	matches := make([]string, 0)
-	for i := range directives {
-		var path string
-		if filepath.IsAbs(directives[i]) {
-			path = directives[i]
-		} else if system {
-			path = filepath.Join("/etc/ssh", directives[i])
-		} else {
-			path = filepath.Join(homedir(), ".ssh", directives[i])
+	patterns, err := splitArgs(strings.Join(directives, " "))
+	if err != nil {
+		return nil, err
+	}
+	if d.noInclude {
+		return inc, nil
+	}
+	// As in OpenSSH, files are included in the order of the directives, and
+	// the files matching each glob in lexical order. A file that matches
+	// more than once is included more than once, though it is only parsed
+	// once.
+	for i := range patterns {
+		path, err := d.includePath(patterns[i])
+		if err != nil {
+			return nil, err
 		}
-		theseMatches, err := filepath.Glob(path)
+		theseMatches, err := d.fs.glob(path)
 		if err != nil {
 			return nil, err
 		}
-		matches = append(matches, theseMatches...)
+		sort.Strings(theseMatches)
+		inc.matches = append(inc.matches, theseMatches...)
 	}
-	matches = removeDups(matches)
-	inc.matches = matches
-	for i := range matches {
-		config, err := parseWithDepth(matches[i], depth)
+	for _, match := range inc.matches {
+		if _, ok := inc.files[match]; ok {
+			continue
+		}
+		config, err := d.parse(match, depth)
 		if err != nil {
 			return nil, err
 		}
-		inc.files[matches[i]] = config
+		inc.files[match] = config
 	}
 	return inc, nil
 }
@@ -1026,7 +1579,6 @@ func (i *Include) Pos() Position {
 func (inc *Include) Get(key string, ctx *MatchContext) string {
 	inc.mu.Lock()
 	defer inc.mu.Unlock()
-	// TODO: we search files in any order which is not correct
 	for i := range inc.matches {
 		cfg := inc.files[inc.matches[i]]
 		if cfg == nil {
@@ -1047,7 +1599,6 @@ func (inc *Include) GetAll(key string, ctx *MatchContext) ([]string, error) {
 	defer inc.mu.Unlock()
 	var vals []string
 
-	// TODO: we search files in any order which is not correct
 	for i := range inc.matches {
 		cfg := inc.files[inc.matches[i]]
 		if cfg == nil {
@@ -1068,17 +1619,28 @@ func (inc *Include) GetAll(key string, ctx *MatchContext) ([]string, error) {
 // String prints out a string representation of this Include directive. Note
 // included Config files are not printed as part of this representation.
 func (inc *Include) String() string {
+	if inc.line.unchanged(inc.fields()) {
+		return inc.line.text
+	}
 	equals := " "
 	if inc.hasEquals {
 		equals = " = "
 	}
-	line := fmt.Sprintf("%sInclude%s%s", strings.Repeat(" ", int(inc.leadingSpace)), equals, strings.Join(inc.directives, " "))
+	line := fmt.Sprintf("%sInclude%s%s", inc.indent, equals, strings.Join(inc.directives, " "))
 	if inc.Comment != "" {
-		line += " #" + inc.Comment
+		space := inc.spaceAfterValue
+		if space == "" {
+			space = " "
+		}
+		line += space + "#" + inc.Comment
 	}
 	return line
 }
 
+func (inc *Include) fields() string {
+	return inc.Comment
+}
+
 var matchAll *Pattern
 
 func init() {
@@ -1090,6 +1652,7 @@ func init() {
 }
 
 func newConfig() *Config {
+	st := defaultStyle
 	return &Config{
 		Blocks: []Block{
 			&Host{
@@ -1097,9 +1660,11 @@ func newConfig() *Config {
 				BlockData: &BlockData{
 					implicit: true,
 					Nodes:    make([]Node, 0),
+					style:    &st,
 				},
 			},
 		},
 		depth: 0,
+		style: &st,
 	}
 }
@@ -2,6 +2,7 @@ package ssh_config
 
 import (
 	"bytes"
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
@@ -290,6 +291,274 @@ func TestIncludeSystem(t *testing.T) {
 	}
 }
 
+// writeFiles writes files, keyed by their path relative to dir, to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var includeOrderTests = []struct {
+	alias string
+	key   string
+	want  string
+}{
+	// conf.d/*.conf is evaluated in lexical order, so the earliest file wins
+	{"web", "User", "base"},
+	{"web", "Port", "2020"},
+	// "9-late.conf" sorts after "20-override.conf"
+	{"web", "Compression", "yes"},
+	// files included by the second directive come after the glob
+	{"web", "ForwardAgent", "no"},
+	{"db", "HostName", "db.override"},
+	// the Include is evaluated where it appears, before "Host *"
+	{"other", "User", "base"},
+	{"other", "ConnectTimeout", "10"},
+}
+
+func TestIncludeOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config":                  "Include " + dir + "/conf.d/*.conf " + dir + "/extra.conf\n\nHost *\n  User default\n  ConnectTimeout 10\n",
+		"conf.d/10-base.conf":     "Host *\n  User base\n",
+		"conf.d/20-override.conf": "Host web\n  User override\n  Port 2020\n\nHost db\n  HostName db.override\n",
+		"conf.d/9-late.conf":      "Host web\n  Port 9999\n  Compression yes\n\nHost db\n  HostName db.late\n",
+		"conf.d/README":           "Host *\n  Port 1\n",
+		"extra.conf":              "Host web\n  Compression no\n  ForwardAgent no\n",
+	})
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder(filepath.Join(dir, "config")),
+		systemConfigFinder: nullConfigFinder,
+	}
+	for _, tt := range includeOrderTests {
+		got, err := us.GetStrict(tt.alias, tt.key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("GetStrict(%q, %q): got %q, want %q", tt.alias, tt.key, got, tt.want)
+		}
+		rc, err := us.Resolve(tt.alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rc.Get(tt.key); got != tt.want {
+			t.Errorf("Resolve(%q).Get(%q): got %q, want %q", tt.alias, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestIncludeRepeated(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.conf": "IdentityFile a\n",
+		"b.conf": "IdentityFile b\n",
+	})
+	c, err := DecodeBytes([]byte("Include " + dir + "/b.conf " + dir + "/*.conf\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := c.Blocks[0].GetNodes()[0].(*Include)
+	want := []string{dir + "/b.conf", dir + "/a.conf", dir + "/b.conf"}
+	if !reflect.DeepEqual(inc.matches, want) {
+		t.Errorf("expected matches %q, got %q", want, inc.matches)
+	}
+	if len(inc.files) != 2 {
+		t.Errorf("expected 2 parsed files, got %d", len(inc.files))
+	}
+}
+
+func TestIncludePath(t *testing.T) {
+	home := homedir()
+	tests := []struct {
+		arg    string
+		system bool
+		want   string
+	}{
+		{"/etc/ssh/ssh_config.d/*.conf", false, "/etc/ssh/ssh_config.d/*.conf"},
+		{"config.d/*", false, filepath.Join(home, ".ssh", "config.d/*")},
+		{"config.d/*", true, "/etc/ssh/config.d/*"},
+		{"~/.ssh/config.d/*", false, home + "/.ssh/config.d/*"},
+		{"~", false, home},
+	}
+	for _, tt := range tests {
+		got, err := newDecoder(osFileSystem, tt.system).includePath(tt.arg)
+		if err != nil {
+			t.Errorf("includePath(%q, %v): %v", tt.arg, tt.system, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("includePath(%q, %v): got %q, want %q", tt.arg, tt.system, got, tt.want)
+		}
+	}
+}
+
+var includeScopeTests = []struct {
+	alias string
+	key   string
+	want  string
+}{
+	// lines before the first Host or Match in an included file belong to
+	// the including block
+	{"cust-a-db", "User", "alice"},
+	{"cust-b-db", "User", "bob"},
+	{"other", "User", "default"},
+	// Host and Match blocks in included files
+	{"cust-a-db", "Port", "5432"},
+	{"cust-a-web", "Port", "8080"},
+	// included files never match if the including block does not
+	{"cust-a-web", "Compression", "no"},
+	{"other", "Port", "22"},
+	// blocks in included files end at the end of the file
+	{"cust-a-web", "ForwardAgent", "yes"},
+	{"cust-b-db", "ForwardAgent", "no"},
+}
+
+func TestIncludeScope(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config": "Host cust-a-*\n  Include " + dir + "/customers/a.conf\n  ForwardAgent yes\n\n" +
+			"Host cust-b-*\n  Include " + dir + "/customers/b.conf\n\nHost *\n  User default\n",
+		"customers/a.conf": "User alice\n\nMatch user alice host cust-a-db\n  Port 5432\n\nHost cust-a-web\n  Port 8080\n",
+		"customers/b.conf": "User bob\n\nMatch exec \"b-vpn-up\"\n  Compression yes\n\nHost *\n  Port 2222\n",
+	})
+	exec := &stubExecutor{}
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder(filepath.Join(dir, "config")),
+		systemConfigFinder: nullConfigFinder,
+		Executor:           exec,
+	}
+	for _, tt := range includeScopeTests {
+		rc, err := us.Resolve(tt.alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rc.Get(tt.key); got != tt.want {
+			t.Errorf("Resolve(%q).Get(%q): got %q, want %q", tt.alias, tt.key, got, tt.want)
+		}
+	}
+	// "Match exec" in b.conf only runs for cust-b-db, once per Resolve.
+	if want := []string{"b-vpn-up", "b-vpn-up"}; !reflect.DeepEqual(exec.calls, want) {
+		t.Errorf("expected commands %q, got %q", want, exec.calls)
+	}
+}
+
+func TestIncludeFinalNotMatching(t *testing.T) {
+	// As in OpenSSH, a "Match final" in a file included from a block that
+	// does not match still requests the final pass, in which Host patterns
+	// are matched against the HostName.
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config": "Host alias\n  HostName real.example.com\n\nHost other\n  Include " + dir + "/final.conf\n\n" +
+			"Host real.example.com\n  Port 2200\n",
+		"final.conf": "Match final\n  User never\n",
+	})
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder(filepath.Join(dir, "config")),
+		systemConfigFinder: nullConfigFinder,
+	}
+	rc, err := us.Resolve("alias", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.Get("Port"); got != "2200" {
+		t.Errorf("Resolve(alias).Get(Port): got %q, want 2200", got)
+	}
+	if got := rc.Get("User"); got == "never" {
+		t.Errorf("Resolve(alias).Get(User): got %q from a block that does not match", got)
+	}
+}
+
+func TestDecodeOptionsInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"conf.d/a.conf": "Include b.conf\nPort 2200\n",
+		"b.conf":        "Include c.conf\nUser b\n",
+		"c.conf":        "User c\n",
+	})
+	in := []byte("Include conf.d/a.conf\n")
+	c, err := DecodeOptions{System: true, BaseDir: dir}.DecodeBytes(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"Port": "2200", "User": "c"} {
+		got, err := c.Get(key, NewMatchContext("host", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Get(%q): got %q, want %q", key, got, want)
+		}
+	}
+
+	_, err = DecodeOptions{BaseDir: dir, MaxDepth: 2}.DecodeBytes(in)
+	if !errors.Is(err, ErrDepthExceeded) {
+		t.Errorf("expected ErrDepthExceeded, got %v", err)
+	}
+	if _, err := (DecodeOptions{BaseDir: dir, MaxDepth: 3}.DecodeBytes(in)); err != nil {
+		t.Errorf("expected nil err, got %v", err)
+	}
+
+	c, err = DecodeOptions{BaseDir: dir, NoInclude: true}.DecodeBytes(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("Port", NewMatchContext("host", "")); got != "" || err != nil {
+		t.Errorf("NoInclude: Get(Port): got %q, %v, want no value", got, err)
+	}
+	if got := c.String(); got != string(in) {
+		t.Errorf("NoInclude: got %q, want %q", got, in)
+	}
+}
+
+func TestDecodeOptionsName(t *testing.T) {
+	_, err := DecodeOptions{Name: "custom.conf"}.DecodeBytes([]byte("Host *\n\tPort 22\nMatch bogus x\n"))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if want := "custom.conf:3:7: Match keyword not supported: bogus"; err.Error() != want {
+		t.Errorf("wrong error: got %q, want %q", err, want)
+	}
+}
+
+var strictTests = []struct {
+	in  string
+	err string
+}{
+	{"Host *\n  Port 22\n  Compression yes\n", ""},
+	{"Host *\n  Bogus yes\n", "2:3: Bad configuration option: Bogus"},
+	{"IgnoreUnknown Bog*,UseKeychain\nHost *\n  Bogus yes\n  UseKeychain yes\n", ""},
+	{"Host *\n  UseKeychain yes\nIgnoreUnknown UseKeychain\n", "2:3: Bad configuration option: UseKeychain"},
+	{"Host *\n  Port notanumber\n", `2:3: ssh_config: strconv.ParseUint: parsing "notanumber": invalid syntax`},
+	{"Host *\n  Compression maybe\n", "2:3: ssh_config: value for key \"Compression\" must be 'yes' or 'no', got \"maybe\""},
+}
+
+func TestDecodeOptionsStrict(t *testing.T) {
+	for _, tt := range strictTests {
+		_, err := DecodeOptions{Strict: true}.DecodeBytes([]byte(tt.in))
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("DecodeBytes(%q): expected nil err, got %v", tt.in, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("DecodeBytes(%q): got err %v, want %q", tt.in, err, tt.err)
+		}
+		// non-strict decoding accepts anything
+		if _, err := DecodeBytes([]byte(tt.in)); err != nil {
+			t.Errorf("DecodeBytes(%q): expected nil err in non-strict mode, got %v", tt.in, err)
+		}
+	}
+}
+
 var recursiveIncludeFile = []byte(`
 Host kevinburke.ssh_config.test.example.com
 	Include kevinburke-ssh-config-recursive-include
@@ -309,7 +578,7 @@ func TestIncludeRecursive(t *testing.T) {
 		userConfigFinder: testConfigFinder("testdata/include-recursive"),
 	}
 	val, err := us.GetStrict("kevinburke.ssh_config.test.example.com", "Port", "")
-	if err != ErrDepthExceeded {
+	if !errors.Is(err, ErrDepthExceeded) {
 		t.Errorf("Recursive include: expected ErrDepthExceeded, got %v", err)
 	}
 	if val != "" {
@@ -352,6 +621,8 @@ var matchTests = []struct {
 	{[]string{"*.*.co.uk"}, "subdomain.bbc.co.uk", true},
 	{[]string{"*.example.com", "!*.dialup.example.com", "foo.dialup.example.com"}, "foo.dialup.example.com", false},
 	{[]string{"test.*", "!test.host"}, "test.host", false},
+	{[]string{"*.Example.com"}, "www.example.COM", true},
+	{[]string{"a,b"}, "a", false},
 }
 
 func TestMatches(t *testing.T) {
@@ -478,6 +749,26 @@ func TestMatchFinalWithCriterion(t *testing.T) {
 	}
 }
 
+func TestMatchNegatedFinalAll(t *testing.T) {
+	cfg, err := DecodeBytes([]byte("Match !all\n\tUser never\n\nMatch !final\n\tPort 2200\n\nHost *\n\tUser everyone\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "!final" does not make a block final.
+	if cfg.Blocks[2].IsFinal() {
+		t.Error("expected Match !final not to be final")
+	}
+	for key, want := range map[string]string{"User": "everyone", "Port": "2200"} {
+		got, err := cfg.Get(key, NewMatchContext("web", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("expected %s to be %q, got %q", key, want, got)
+		}
+	}
+}
+
 func TestIndexInRange(t *testing.T) {
 	us := &UserSettings{
 		userConfigFinder: testConfigFinder("testdata/config4"),
@@ -604,3 +895,331 @@ func TestModifierCaret(t *testing.T) {
 		t.Errorf("expected Ciphers not to contain %q, got %q", "dummy", c)
 	}
 }
+
+func TestMatchTagged(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-tagged"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	user, err := us.GetStrict("web.prod.example.com", "User", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "deploy" {
+		t.Errorf("expected User to be %q, got %q", "deploy", user)
+	}
+	port, err := us.GetStrict("db-1", "Port", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != "5432" {
+		t.Errorf("expected Port to be %q, got %q", "5432", port)
+	}
+}
+
+func TestMatchTaggedByCaller(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-tagged"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	ctx := NewMatchContext("web.prod.example.com", "")
+	ctx.Tag = "database"
+	rc, err := us.ResolveContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Get("Tag") != "database" {
+		t.Errorf("expected Tag to be %q, got %q", "database", rc.Get("Tag"))
+	}
+	if rc.Get("Port") != "5432" {
+		t.Errorf("expected Port to be %q, got %q", "5432", rc.Get("Port"))
+	}
+	if rc.Get("User") != "" {
+		t.Errorf("expected no User, got %q", rc.Get("User"))
+	}
+}
+
+func TestMatchTaggedNoTag(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-tagged"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	port, err := us.GetStrict("web.example.com", "Port", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != "22" {
+		t.Errorf("expected Port to be %q, got %q", "22", port)
+	}
+}
+
+var matchSessionTests = []struct {
+	version     string
+	sessionType string
+	command     string
+	key         string
+	want        string
+}{
+	{"", "", "", "RequestTTY", "yes"},
+	{"", "subsystem", "sftp", "RequestTTY", "no"},
+	{"", "subsystem", "sftp", "Compression", "yes"},
+	{"", "", "rsync --server -logDtpre.iLsfxCIvu . /srv", "Compression", "no"},
+	{"", "", "rsync --server -logDtpre.iLsfxCIvu . /srv", "RequestTTY", ""},
+	{"", "none", "", "RequestTTY", ""},
+	{"OpenSSH_9.8", "", "", "ForwardAgent", "yes"},
+	{"OpenSSH_10.0", "", "", "ForwardAgent", "no"},
+}
+
+func TestMatchSession(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-session"),
+		systemConfigFinder: nullConfigFinder,
+	}
+	for _, tt := range matchSessionTests {
+		ctx := NewMatchContext("example.com", "")
+		ctx.Version = tt.version
+		ctx.SessionType = tt.sessionType
+		ctx.Command = tt.command
+		rc, err := us.ResolveContext(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rc.Get(tt.key); got != tt.want {
+			t.Errorf("version %q, session type %q, command %q: expected %s %q, got %q", tt.version, tt.sessionType, tt.command, tt.key, tt.want, got)
+		}
+	}
+}
+
+var matchListTests = []struct {
+	alias string
+	user  string
+	want  string
+}{
+	{"a.example.com", "", "1001"},
+	{"B.EXAMPLE.COM", "", "1001"},
+	{"c.example.com", "", "22"},
+	{"d.example.com", "", "1002"},
+	{"www.example.org", "", "1003"},
+	{"api.example.org", "", "22"},
+	{"api.example.org", "Deploy", "1004"},
+	{"api.example.org", "deploy", "22"},
+	{"example.net", "", "1005"},
+}
+
+func TestMatchPatternLists(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-lists"),
+		systemConfigFinder: nullConfigFinder,
+	}
+	for _, tt := range matchListTests {
+		port, err := us.GetStrict(tt.alias, "Port", tt.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != tt.want {
+			t.Errorf("alias %q, user %q: expected Port to be %q, got %q", tt.alias, tt.user, tt.want, port)
+		}
+	}
+}
+
+var patternListTests = []struct {
+	list string
+	in   string
+	want bool
+}{
+	{"*", "example.com", true},
+	{"a.example.com,b.example.com", "b.example.com", true},
+	{"a.example.com,b.example.com", "c.example.com", false},
+	{"*.example.com,!c.example.com", "c.example.com", false},
+	{"!c.example.com,*.example.com", "c.example.com", false},
+	{"!c.example.com", "d.example.com", false},
+	{"*.EXAMPLE.com", "www.example.com", true},
+}
+
+func TestPatternList(t *testing.T) {
+	for _, tt := range patternListTests {
+		l, err := NewPatternList(tt.list)
+		if err != nil {
+			t.Fatalf("error compiling pattern list %s: %v", tt.list, err)
+		}
+		if got := l.Matches(tt.in); got != tt.want {
+			t.Errorf("PatternList(%q).Matches(%q): got %v, want %v", tt.list, tt.in, got, tt.want)
+		}
+	}
+	if _, err := NewPatternList("a,,b"); err == nil {
+		t.Error("expected error for empty pattern in list, got nil")
+	}
+}
+
+func TestHostPatternString(t *testing.T) {
+	in := "Host *.example.com !bastion.example.com \"with space\"\n    Port 22\n"
+	cfg, err := DecodeBytes([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.String(); got != in {
+		t.Errorf("round trip: got %q, want %q", got, in)
+	}
+}
+
+var roundTripTests = []string{
+	"Host a\n\tUser b\n",
+	"Host a\r\n  User b\r\n\r\n# c\r\n",
+	"Host a\r\n  User b\n\r\n",
+	"\ufeffHost a\n  User b\n",
+	"Host a  \n  User b   \n",
+	"Host a\n  User b",
+	"Host a\n  ",
+	"   \n\t\n",
+	"# only a comment",
+	"Include nonexistent-file   # x\n",
+	"User   =   b\t# c\nHost=a\n\tPort=22\n",
+	"Match host a # c\n\tPort 22\n",
+	"  MATCH final ALL\r\n\tPort 22\r\n",
+	"match = !canonical User \"a b\",'c'   #c\n",
+	"Host \"with space\" b\t\t#c\n",
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, in := range roundTripTests {
+		cfg, err := DecodeBytes([]byte(in))
+		if err != nil {
+			t.Errorf("DecodeBytes(%q): %v", in, err)
+			continue
+		}
+		if got := cfg.String(); got != in {
+			t.Errorf("round trip:\ngot  %q\nwant %q", got, in)
+		}
+		b, err := cfg.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != in {
+			t.Errorf("MarshalText:\ngot  %q\nwant %q", b, in)
+		}
+	}
+}
+
+func TestRoundTripTolerant(t *testing.T) {
+	in := "Host a\r\n\tBogus yes # c\r\n\tUser b\r\nMatch bogus\r\n\tUser c\r\n"
+	cfg, err := DecodeOptions{Tolerant: true, Strict: true}.DecodeBytes([]byte(in))
+	if cfg == nil {
+		t.Fatal(err)
+	}
+	if got := cfg.String(); got != in {
+		t.Errorf("round trip:\ngot  %q\nwant %q", got, in)
+	}
+}
+
+func TestRoundTripEdit(t *testing.T) {
+	in := "Host a\r\n\tUser b  # comment\r\n\t# note\r\n\tPort 22\r\n"
+	cfg, err := DecodeBytes([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := cfg.Blocks[1].(*Host)
+	host.Nodes[0].(*KV).Value = "c"
+	host.Nodes[1].(*Empty).Comment = " changed"
+	want := "Host a\r\n\tUser c  # comment\r\n\t# changed\r\n\tPort 22\r\n"
+	if got := cfg.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	pat, err := NewPattern("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Patterns = append(host.Patterns, pat)
+	want = "Host a b\r\n\tUser c  # comment\r\n\t# changed\r\n\tPort 22\r\n"
+	if got := cfg.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+var matchStringTests = []struct {
+	in   string
+	edit func(m *Match)
+	want string
+}{
+	{
+		"Match Host a,b !Exec \"test -f x\" # c\n\tPort 22\n",
+		func(m *Match) { m.Criteria[0].Arg = "c" },
+		"Match Host c !Exec \"test -f x\" # c\n\tPort 22\n",
+	},
+	{
+		"\tmatch = final  ALL\r\n\tPort 22\r\n",
+		func(m *Match) { m.EOLComment = " note" },
+		"\tmatch = final ALL# note\r\n\tPort 22\r\n",
+	},
+	{
+		"Match user a\n",
+		func(m *Match) { m.Criteria[0].Negated = true },
+		"Match !user a\n",
+	},
+	{
+		"Match exec true\n",
+		func(m *Match) { m.Criteria[0].Arg = `say "hi"` },
+		"Match exec \"say \\\"hi\\\"\"\n",
+	},
+	{
+		"Match all\n",
+		func(m *Match) {
+			m.Criteria = append([]*MatchCriterion{{Keyword: "canonical"}}, m.Criteria...)
+		},
+		"Match canonical all\n",
+	},
+}
+
+func TestMatchString(t *testing.T) {
+	for _, tt := range matchStringTests {
+		cfg, err := DecodeBytes([]byte(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.String(); got != tt.in {
+			t.Errorf("round trip:\ngot  %q\nwant %q", got, tt.in)
+		}
+		tt.edit(cfg.Blocks[1].(*Match))
+		if got := cfg.String(); got != tt.want {
+			t.Errorf("edited %q:\ngot  %q\nwant %q", tt.in, got, tt.want)
+		}
+		// The printed config reads back the same.
+		again, err := DecodeBytes([]byte(tt.want))
+		if err != nil {
+			t.Fatalf("DecodeBytes(%q): %v", tt.want, err)
+		}
+		if got := again.String(); got != tt.want {
+			t.Errorf("round trip:\ngot  %q\nwant %q", got, tt.want)
+		}
+	}
+
+	m := &Match{
+		Criteria: []*MatchCriterion{
+			{Keyword: "host", Arg: "a b"},
+			{Keyword: "localuser", Arg: ""},
+		},
+		BlockData: &BlockData{},
+	}
+	if got, want := m.String(), "Match host \"a b\" localuser \"\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMatchStringTestdata(t *testing.T) {
+	for _, name := range []string{"match-directive", "match-exec", "match-final", "match-lists", "match-localnetwork", "match-session", "match-tagged", "match-user"} {
+		b, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := DecodeBytes(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.String(); got != string(b) {
+			t.Errorf("%s: round trip:\ngot  %q\nwant %q", name, got, b)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package ssh_config
+
+import (
+	"reflect"
+	"testing"
+)
+
+// conformanceTests check the evaluation order documented in ssh_config(5)
+// and implemented by readconf.c in OpenSSH: the first value obtained for a
+// keyword is used, and the configuration is parsed a second time after
+// hostname canonicalization, or if "Match final" is used.
+var conformanceTests = []struct {
+	file  string
+	alias string
+	want  map[string]string
+	all   map[string][]string
+}{
+	{
+		file:  "first-value-wins",
+		alias: "foo",
+		want:  map[string]string{"HostName": "foo.example.com", "Port": "1", "User": "canon"},
+	},
+	{
+		file:  "canonical-negated",
+		alias: "foo",
+		want:  map[string]string{"User": "first", "Port": "2"},
+	},
+	{
+		file:  "match-final",
+		alias: "anything",
+		want:  map[string]string{"User": "early", "Compression": "yes"},
+		all:   map[string][]string{"IdentityFile": {"~/.ssh/common", "~/.ssh/final"}},
+	},
+	{
+		file:  "canonical-final",
+		alias: "web",
+		want:  map[string]string{"Port": "2022", "ForwardAgent": "yes"},
+	},
+	{
+		file:  "canonical-no-final",
+		alias: "web",
+		want:  map[string]string{"Port": "22"},
+	},
+	{
+		file:  "negated-final",
+		alias: "web",
+		want:  map[string]string{"User": "first", "Port": "2200", "Compression": "no"},
+	},
+	{
+		file:  "negated-all",
+		alias: "web",
+		want:  map[string]string{"User": "everyone"},
+	},
+	{
+		file:  "final-hostname",
+		alias: "alias",
+		want: map[string]string{
+			"HostName":    "real.example.com",
+			"Port":        "2200",
+			"User":        "finaluser",
+			"Compression": "yes",
+		},
+	},
+}
+
+var conformanceResolver = fakeResolver{
+	"foo.example.com.": "foo.example.com.",
+}
+
+func TestConformance(t *testing.T) {
+	for _, tt := range conformanceTests {
+		us := &UserSettings{
+			userConfigFinder:   testConfigFinder("testdata/conformance/" + tt.file),
+			systemConfigFinder: nullConfigFinder,
+			HostResolver:       conformanceResolver,
+		}
+		rc, err := us.Resolve(tt.alias, "")
+		if err != nil {
+			t.Errorf("%s: Resolve(%q): %v", tt.file, tt.alias, err)
+			continue
+		}
+		for key, want := range tt.want {
+			if got := rc.Get(key); got != want {
+				t.Errorf("%s: Resolve(%q): expected %s %q, got %q", tt.file, tt.alias, key, want, got)
+			}
+			// GetStrict must agree with Resolve.
+			got, err := us.GetStrict(tt.alias, key, "")
+			if err != nil {
+				t.Errorf("%s: GetStrict(%q, %q): %v", tt.file, tt.alias, key, err)
+			} else if got != want {
+				t.Errorf("%s: GetStrict(%q, %q): expected %q, got %q", tt.file, tt.alias, key, want, got)
+			}
+		}
+		for key, want := range tt.all {
+			if got := rc.GetAll(key); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: Resolve(%q): expected %s %q, got %q", tt.file, tt.alias, key, want, got)
+			}
+			got, err := us.GetAllStrict(tt.alias, key, "")
+			if err != nil {
+				t.Errorf("%s: GetAllStrict(%q, %q): %v", tt.file, tt.alias, key, err)
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: GetAllStrict(%q, %q): expected %q, got %q", tt.file, tt.alias, key, want, got)
+			}
+		}
+	}
+}
+
+func TestGetRepeatable(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-final"),
+		systemConfigFinder: nullConfigFinder,
+	}
+	for i := 0; i < 3; i++ {
+		port, err := us.GetStrict("testhost", "Port", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != "4567" {
+			t.Errorf("call %d: expected Port 4567, got %q", i, port)
+		}
+	}
+
+	cfg, err := parseFile("testdata/match-final")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewMatchContext("testhost", "")
+	for i := 0; i < 3; i++ {
+		if _, err := cfg.Get("Port", ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ctx.FinalBlocks) != 0 {
+		t.Errorf("expected no FinalBlocks, got %d", len(ctx.FinalBlocks))
+	}
+}
@@ -0,0 +1,381 @@
+package ssh_config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// style is the formatting of lines added to a config by the editing methods.
+// It is inferred from the file the config was read from; a single style is
+// shared by the config and all of its blocks.
+type style struct {
+	indent string // Whitespace before the keys of Host and Match blocks
+	equals bool   // Keys and values are separated by " = "
+	eol    string // The line terminator
+}
+
+var defaultStyle = style{indent: "  ", eol: "\n"}
+
+// inferStyle sets the style of c from its nodes: the indentation and equals
+// style of the first key in a Host or Match block, or else of any key, and the
+// terminator of the first line.
+func (c *Config) inferStyle(lines []sourceLine) {
+	st := c.style
+	for _, l := range lines {
+		if l.eol != "" {
+			st.eol = l.eol
+			break
+		}
+	}
+	var first *KV
+	for i, block := range c.Blocks {
+		for _, node := range block.GetNodes() {
+			kv, ok := node.(*KV)
+			if !ok {
+				continue
+			}
+			if i > 0 {
+				st.indent, st.equals = kv.indent, kv.hasEquals
+				return
+			}
+			if first == nil {
+				first = kv
+			}
+		}
+	}
+	if first != nil {
+		st.equals = first.hasEquals
+	}
+}
+
+// styleOf returns the style of lines added to b: that of the first key in b,
+// or the style of the file.
+func (b *BlockData) styleOf() style {
+	st := defaultStyle
+	if b.style != nil {
+		st = *b.style
+	}
+	if b.implicit {
+		st.indent = ""
+	}
+	for _, node := range b.Nodes {
+		if kv, ok := node.(*KV); ok {
+			st.indent, st.equals = kv.indent, kv.hasEquals
+			if kv.line.eol != "" {
+				st.eol = kv.line.eol
+			}
+			break
+		}
+	}
+	return st
+}
+
+// blank returns a new empty line.
+func (st style) blank() *Empty {
+	return &Empty{line: sourceLine{eol: st.eol}}
+}
+
+// checkKV returns an error if key and value cannot be written as a line of
+// a block.
+func checkKV(key, value string) error {
+	switch strings.ToLower(key) {
+	case "host", "match", "include":
+		return fmt.Errorf("ssh_config: cannot set %s in a block", key)
+	}
+	if key == "" || strings.ContainsAny(key, " \t=#\r\n\"") {
+		return fmt.Errorf("ssh_config: invalid key %q", key)
+	}
+	if strings.TrimSpace(value) == "" || strings.ContainsAny(value, "#\r\n") {
+		return fmt.Errorf("ssh_config: invalid value %q for %s", value, key)
+	}
+	return nil
+}
+
+// Set sets key to value in the block. If the block contains key, the value of
+// the first line for key is changed, and later lines for key are removed;
+// the comment on the line is kept. Otherwise a line is added after the last
+// key in the block, with the indentation and equals style of the other keys
+// in the block, or of the file.
+//
+// Keys are compared case-insensitively. Set returns an error for the Host,
+// Match and Include keywords, and for values that cannot be written on a
+// single line.
+func (b *BlockData) Set(key, value string) error {
+	if err := checkKV(key, value); err != nil {
+		return err
+	}
+	found := false
+	nodes := b.Nodes[:0]
+	for _, node := range b.Nodes {
+		if kv, ok := node.(*KV); ok && strings.EqualFold(kv.Key, key) {
+			if found {
+				continue
+			}
+			kv.Value = value
+			found = true
+		}
+		nodes = append(nodes, node)
+	}
+	for i := len(nodes); i < len(b.Nodes); i++ {
+		b.Nodes[i] = nil
+	}
+	b.Nodes = nodes
+	if !found {
+		b.insert(b.end(), key, value)
+	}
+	return nil
+}
+
+// Unset removes all lines for key from the block, along with their comments,
+// and reports whether there were any. Keys are compared case-insensitively.
+func (b *BlockData) Unset(key string) bool {
+	n := len(b.Nodes)
+	b.Nodes = slices.DeleteFunc(b.Nodes, func(node Node) bool {
+		kv, ok := node.(*KV)
+		return ok && strings.EqualFold(kv.Key, key)
+	})
+	return len(b.Nodes) < n
+}
+
+// Append adds a line for key to the block, after the last line for key, or
+// after the last key in the block. Use it for keys that may be given more
+// than once, like IdentityFile or LocalForward. It returns the same errors as
+// Set.
+func (b *BlockData) Append(key, value string) error {
+	if err := checkKV(key, value); err != nil {
+		return err
+	}
+	i := -1
+	for j, node := range b.Nodes {
+		if kv, ok := node.(*KV); ok && strings.EqualFold(kv.Key, key) {
+			i = j + 1
+		}
+	}
+	if i < 0 {
+		i = b.end()
+	}
+	b.insert(i, key, value)
+	return nil
+}
+
+// end returns the index after the last key or Include in the block. The
+// empty lines and comments after it separate the block from the next one.
+func (b *BlockData) end() int {
+	for i := len(b.Nodes); i > 0; i-- {
+		if _, ok := b.Nodes[i-1].(*Empty); !ok {
+			return i
+		}
+	}
+	return 0
+}
+
+func (b *BlockData) insert(i int, key, value string) {
+	st := b.styleOf()
+	kv := &KV{
+		Key:       key,
+		Value:     value,
+		hasEquals: st.equals,
+		indent:    st.indent,
+		line:      sourceLine{eol: st.eol},
+	}
+	// A new line after an unterminated last line terminates it.
+	if i == len(b.Nodes) && i > 0 {
+		setTerminator(b.Nodes[i-1], st.eol)
+	}
+	b.Nodes = slices.Insert(b.Nodes, i, Node(kv))
+}
+
+// setTerminator sets the line terminator of node to eol, if it has none.
+func setTerminator(node Node, eol string) {
+	var l *sourceLine
+	switch t := node.(type) {
+	case *KV:
+		l = &t.line
+	case *Empty:
+		l = &t.line
+	case *Include:
+		l = &t.line
+	default:
+		return
+	}
+	if l.lineEnding() == "" {
+		l.eol = eol
+	}
+}
+
+var (
+	errBlockNotFound = errors.New("ssh_config: block not found in config")
+	errImplicitBlock = errors.New("ssh_config: cannot move or remove the implicit block at the start of the config")
+)
+
+// AddHost adds a Host block with the given patterns at the end of c, after an
+// empty line, and returns it. Add keys to the block with Set and Append.
+//
+// Since the first value obtained for a key is used, a host added after a
+// "Host *" block does not override its values; use MoveBlock to move the new
+// block in front of it.
+func (c *Config) AddHost(patterns ...string) (*Host, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("ssh_config: no patterns for Host")
+	}
+	h := &Host{
+		Patterns: make([]*Pattern, 0, len(patterns)),
+		BlockData: &BlockData{
+			Nodes: make([]Node, 0),
+			style: c.style,
+		},
+	}
+	for _, s := range patterns {
+		if s == "" || strings.ContainsAny(s, " \t#\r\n\"") {
+			return nil, fmt.Errorf("ssh_config: invalid pattern %q", s)
+		}
+		pat, err := NewPattern(s)
+		if err != nil {
+			return nil, err
+		}
+		h.Patterns = append(h.Patterns, pat)
+	}
+	h.line.eol = c.editStyle().eol
+	c.insertBlock(len(c.Blocks), h, nil)
+	c.reindex()
+	return h, nil
+}
+
+// RemoveBlock removes the block b from c, along with the comment lines
+// directly above it. The implicit block at the start of c cannot be removed.
+func (c *Config) RemoveBlock(b Block) error {
+	i, err := c.blockIndex(b)
+	if err != nil {
+		return err
+	}
+	last := i == len(c.Blocks)-1
+	c.removeBlock(i)
+	if last {
+		c.trimSeparator()
+	}
+	c.reindex()
+	return nil
+}
+
+// MoveBlock moves the block b to index i of c.Blocks, along with the comment
+// lines directly above it. Blocks are separated by an empty line where
+// needed. Since the implicit block at the start of c must stay first, i must
+// be at least 1.
+func (c *Config) MoveBlock(b Block, i int) error {
+	j, err := c.blockIndex(b)
+	if err != nil {
+		return err
+	}
+	if i < 1 || i >= len(c.Blocks) {
+		return fmt.Errorf("ssh_config: block index %d out of range [1, %d)", i, len(c.Blocks))
+	}
+	if i == j {
+		return nil
+	}
+	last := j == len(c.Blocks)-1 || i == len(c.Blocks)-1
+	comments := c.removeBlock(j)
+	c.insertBlock(i, b, comments)
+	if last {
+		c.trimSeparator()
+	}
+	c.reindex()
+	return nil
+}
+
+// blockIndex returns the index of b in c.Blocks.
+func (c *Config) blockIndex(b Block) (int, error) {
+	for i, block := range c.Blocks {
+		if block != b {
+			continue
+		}
+		if i == 0 {
+			return 0, errImplicitBlock
+		}
+		return i, nil
+	}
+	return 0, errBlockNotFound
+}
+
+// removeBlock removes the block at index i > 0 from c, and returns the comment
+// lines directly above it, which are the last nodes of the block before it.
+func (c *Config) removeBlock(i int) []Node {
+	prev := c.Blocks[i-1]
+	nodes := prev.GetNodes()
+	j := attachedComments(nodes)
+	comments := slices.Clone(nodes[j:])
+	prev.SetNodes(nodes[:j])
+	c.Blocks = slices.Delete(c.Blocks, i, i+1)
+	return comments
+}
+
+// attachedComments returns the index of the comment lines at the end of nodes,
+// which are directly above the block that follows.
+func attachedComments(nodes []Node) int {
+	i := len(nodes)
+	for i > 0 {
+		if _, ok := nodes[i-1].(*Empty); !ok || isBlank(nodes[i-1]) {
+			break
+		}
+		i--
+	}
+	return i
+}
+
+// insertBlock inserts b at index i > 0 of c, preceded by the comment lines
+// directly above it. Blocks are separated by an empty line, unless the block
+// before b is the empty implicit block at the start of the file.
+func (c *Config) insertBlock(i int, b Block, comments []Node) {
+	st := c.editStyle()
+	prev := c.Blocks[i-1]
+	nodes := prev.GetNodes()
+	if len(nodes) > 0 && !isBlank(nodes[len(nodes)-1]) || i > 1 && len(nodes) == 0 {
+		if len(nodes) > 0 {
+			setTerminator(nodes[len(nodes)-1], st.eol)
+		}
+		nodes = append(nodes, st.blank())
+	}
+	prev.SetNodes(append(nodes, comments...))
+	c.Blocks = slices.Insert(c.Blocks, i, b)
+	if i+1 < len(c.Blocks) {
+		nodes := b.GetNodes()
+		if len(nodes) == 0 || !isBlank(nodes[len(nodes)-1]) {
+			if len(nodes) > 0 {
+				setTerminator(nodes[len(nodes)-1], st.eol)
+			}
+			b.SetNodes(append(nodes, st.blank()))
+		}
+	}
+}
+
+// trimSeparator removes the empty line at the end of the last block of c,
+// which separated it from the block that followed it before that block was
+// moved or removed. Other empty lines at the end of c are kept.
+func (c *Config) trimSeparator() {
+	last := c.Blocks[len(c.Blocks)-1]
+	nodes := last.GetNodes()
+	if n := len(nodes); n > 0 && isBlank(nodes[n-1]) {
+		last.SetNodes(nodes[:n-1])
+	}
+}
+
+// isBlank reports whether node is an empty line without a comment.
+func isBlank(node Node) bool {
+	e, ok := node.(*Empty)
+	return ok && strings.TrimSpace(e.String()) == ""
+}
+
+func (c *Config) editStyle() style {
+	if c.style == nil {
+		return defaultStyle
+	}
+	return *c.style
+}
+
+// reindex rebuilds the index of c after its blocks have changed, if c has one.
+func (c *Config) reindex() {
+	if c.index != nil {
+		c.BuildIndex()
+	}
+}
@@ -0,0 +1,239 @@
+package ssh_config
+
+import (
+	"testing"
+)
+
+var editTests = []struct {
+	name string
+	in   string
+	edit func(t *testing.T, c *Config)
+	want string
+}{
+	{
+		"set existing",
+		"Host a\n  User b # who\n  Port 22\n  User c\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.Blocks[1].(*Host).Set("user", "d")) },
+		"Host a\n  User d # who\n  Port 22\n",
+	},
+	{
+		"set new keeps separator",
+		"Host a\n\tHostName a.example.com\n\n# b\nHost b\n\tPort 22\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.Blocks[1].(*Host).Set("User", "x")) },
+		"Host a\n\tHostName a.example.com\n\tUser x\n\n# b\nHost b\n\tPort 22\n",
+	},
+	{
+		"set in empty block uses file style",
+		"Host a\r\n    Port = 22\r\nHost b\r\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.Blocks[2].(*Host).Set("User", "x")) },
+		"Host a\r\n    Port = 22\r\nHost b\r\n    User = x\r\n",
+	},
+	{
+		"set at unterminated end",
+		"Host a\n  Port 22",
+		func(t *testing.T, c *Config) { mustOK(t, c.Blocks[1].(*Host).Set("User", "x")) },
+		"Host a\n  Port 22\n  User x\n",
+	},
+	{
+		"set global",
+		"# global\nCompression yes\n\nHost a\n  Port 22\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.Blocks[0].(*Host).Set("User", "x")) },
+		"# global\nCompression yes\nUser x\n\nHost a\n  Port 22\n",
+	},
+	{
+		"unset",
+		"Host a\n  IdentityFile x # one\n  Port 22\n  identityfile y\n",
+		func(t *testing.T, c *Config) {
+			if !c.Blocks[1].(*Host).Unset("IdentityFile") {
+				t.Error("Unset: got false, want true")
+			}
+			if c.Blocks[1].(*Host).Unset("User") {
+				t.Error("Unset: got true, want false")
+			}
+		},
+		"Host a\n  Port 22\n",
+	},
+	{
+		"append",
+		"Host a\n  IdentityFile x\n  Port 22\n\nHost b\n",
+		func(t *testing.T, c *Config) {
+			mustOK(t, c.Blocks[1].(*Host).Append("IdentityFile", "y"))
+			mustOK(t, c.Blocks[1].(*Host).Append("LocalForward", "8080 localhost:80"))
+		},
+		"Host a\n  IdentityFile x\n  IdentityFile y\n  Port 22\n  LocalForward 8080 localhost:80\n\nHost b\n",
+	},
+	{
+		"add host",
+		"Host a\n\tPort 22\n",
+		func(t *testing.T, c *Config) {
+			h, err := c.AddHost("bastion", "*.prod")
+			mustOK(t, err)
+			mustOK(t, h.Set("HostName", "10.0.0.1"))
+			mustOK(t, h.Set("User", "ops"))
+		},
+		"Host a\n\tPort 22\n\nHost bastion *.prod\n\tHostName 10.0.0.1\n\tUser ops\n",
+	},
+	{
+		"add host to empty config",
+		"",
+		func(t *testing.T, c *Config) {
+			h, err := c.AddHost("a")
+			mustOK(t, err)
+			mustOK(t, h.Set("Port", "22"))
+		},
+		"Host a\n  Port 22\n",
+	},
+	{
+		"add host after unterminated line",
+		"Host a\r\n  Port 22",
+		func(t *testing.T, c *Config) {
+			_, err := c.AddHost("b")
+			mustOK(t, err)
+		},
+		"Host a\r\n  Port 22\r\n\r\nHost b\r\n",
+	},
+	{
+		"remove block with its comments",
+		"Host a\n  Port 1\n\n# about b\n# more\nHost b\n  Port 2\n\nHost c\n  Port 3\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.RemoveBlock(c.Blocks[2])) },
+		"Host a\n  Port 1\n\nHost c\n  Port 3\n",
+	},
+	{
+		"remove block with an empty comment",
+		"Host a\n  Port 1\n\n#\n# about b\nHost b\n  Port 2\n\nHost c\n  Port 3\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.RemoveBlock(c.Blocks[2])) },
+		"Host a\n  Port 1\n\nHost c\n  Port 3\n",
+	},
+	{
+		"remove last block",
+		"Host a\n  Port 1\n\nHost b\n  Port 2\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.RemoveBlock(c.Blocks[2])) },
+		"Host a\n  Port 1\n",
+	},
+	{
+		"remove block keeps trailing empty lines",
+		"Host a\n  Port 1\n\nHost b\n  Port 2\n\n\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.RemoveBlock(c.Blocks[1])) },
+		"Host b\n  Port 2\n\n\n",
+	},
+	{
+		"move block with its comments",
+		"Host *\n  User all\n\n# bastion\nHost bastion\n  User ops\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.MoveBlock(c.Blocks[2], 1)) },
+		"# bastion\nHost bastion\n  User ops\n\nHost *\n  User all\n",
+	},
+	{
+		"move block to end",
+		"Host a\n  Port 1\n\nHost b\n  Port 2\n\nHost c\n  Port 3\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.MoveBlock(c.Blocks[1], 3)) },
+		"Host b\n  Port 2\n\nHost c\n  Port 3\n\nHost a\n  Port 1\n",
+	},
+	{
+		"move block keeps trailing empty lines",
+		"Host a\n  Port 1\n\nHost b\n  Port 2\n\n\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.MoveBlock(c.Blocks[1], 2)) },
+		"Host b\n  Port 2\n\n\nHost a\n  Port 1\n",
+	},
+	{
+		"move last block keeps empty lines above it",
+		"Host a\n  Port 1\n\n\nHost b\n  Port 2\n",
+		func(t *testing.T, c *Config) { mustOK(t, c.MoveBlock(c.Blocks[2], 1)) },
+		"Host b\n  Port 2\n\nHost a\n  Port 1\n\n",
+	},
+}
+
+func mustOK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	for _, tt := range editTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := DecodeBytes([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.edit(t, cfg)
+			got := cfg.String()
+			if got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+			// The result reads back as the same config.
+			again, err := DecodeBytes([]byte(got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := again.String(); s != got {
+				t.Errorf("read back as %q", s)
+			}
+		})
+	}
+}
+
+func TestEditErrors(t *testing.T) {
+	cfg, err := DecodeBytes([]byte("Host a\n  Port 22\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := cfg.Blocks[1].(*Host)
+	for _, kv := range [][2]string{
+		{"Host", "b"},
+		{"include", "b"},
+		{"", "b"},
+		{"User name", "b"},
+		{"User", ""},
+		{"User", "a # b"},
+		{"User", "a\nHost b"},
+	} {
+		if err := block.Set(kv[0], kv[1]); err == nil {
+			t.Errorf("Set(%q, %q): expected an error", kv[0], kv[1])
+		}
+		if err := block.Append(kv[0], kv[1]); err == nil {
+			t.Errorf("Append(%q, %q): expected an error", kv[0], kv[1])
+		}
+	}
+	if _, err := cfg.AddHost(); err == nil {
+		t.Error("AddHost(): expected an error")
+	}
+	if _, err := cfg.AddHost("a b"); err == nil {
+		t.Error("AddHost(\"a b\"): expected an error")
+	}
+	if err := cfg.RemoveBlock(cfg.Blocks[0]); err != errImplicitBlock {
+		t.Errorf("RemoveBlock(implicit): got %v, want %v", err, errImplicitBlock)
+	}
+	if err := cfg.RemoveBlock(&Host{BlockData: &BlockData{}}); err != errBlockNotFound {
+		t.Errorf("RemoveBlock(other): got %v, want %v", err, errBlockNotFound)
+	}
+	if err := cfg.MoveBlock(block, 0); err == nil {
+		t.Error("MoveBlock(block, 0): expected an error")
+	}
+	if got := cfg.String(); got != "Host a\n  Port 22\n" {
+		t.Errorf("config changed by failed edits: %q", got)
+	}
+}
+
+func TestEditIndex(t *testing.T) {
+	cfg, err := DecodeBytes([]byte("Host a\n  User a\nHost *\n  User all\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BuildIndex()
+	h, err := cfg.AddHost("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustOK(t, h.Set("User", "b"))
+	mustOK(t, cfg.MoveBlock(h, 1))
+	for host, want := range map[string]string{"a": "a", "b": "b", "c": "all"} {
+		got, err := cfg.Get("User", &MatchContext{Host: host, OriginalHost: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Get(%q, User): got %q, want %q", host, got, want)
+		}
+	}
+}
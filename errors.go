@@ -0,0 +1,107 @@
+package ssh_config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The causes of a ParseError. Use errors.Is to check for them.
+var (
+	// ErrSyntax is the cause of errors in the structure of a line, such as a
+	// keyword without a value or an unterminated quote.
+	ErrSyntax = errors.New("ssh_config: syntax error")
+	// ErrInvalidMatch is the cause of errors in the criteria of a Match
+	// directive.
+	ErrInvalidMatch = errors.New("ssh_config: invalid Match directive")
+	// ErrInvalidPattern is the cause of errors in the patterns of a Host
+	// directive or a Match criterion.
+	ErrInvalidPattern = errors.New("ssh_config: invalid pattern")
+	// ErrUnknownKeyword is the cause of errors for keywords that ssh does
+	// not know, reported when decoding with DecodeOptions.Strict.
+	ErrUnknownKeyword = errors.New("ssh_config: bad configuration option")
+	// ErrInvalidValue is the cause of errors for invalid values, such as a
+	// Port that is not a number, reported when decoding with
+	// DecodeOptions.Strict.
+	ErrInvalidValue = errors.New("ssh_config: invalid value")
+	// ErrInclude is the cause of errors finding or reading the files of an
+	// Include directive. Errors in the included files themselves are
+	// reported with the position in the included file.
+	ErrInclude = errors.New("ssh_config: invalid Include directive")
+)
+
+// ParseError describes an error in a config file.
+type ParseError struct {
+	// Filename is the name of the file the error is in, if known.
+	Filename string
+	// Position is the position of the error within the file.
+	Position Position
+	// Line is the text of the offending line, without the line terminator.
+	Line string
+	// IncludeChain lists the Include directives through which the file was
+	// read, starting with the one in the outermost file. It is empty if the
+	// error is in the file that was decoded.
+	IncludeChain []IncludeStep
+	// Msg describes the error.
+	Msg string
+	// Err is the cause of the error: ErrDepthExceeded or one of the errors
+	// above, possibly wrapping an underlying error.
+	Err error
+}
+
+// IncludeStep is the location of an Include directive.
+type IncludeStep struct {
+	Filename string
+	Position Position
+}
+
+func (e *ParseError) Error() string {
+	var buf strings.Builder
+	if e.Filename != "" {
+		buf.WriteString(e.Filename)
+		buf.WriteByte(':')
+	}
+	fmt.Fprintf(&buf, "%d:%d: %s", e.Position.Line, e.Position.Col, e.Msg)
+	for i := len(e.IncludeChain) - 1; i >= 0; i-- {
+		step := e.IncludeChain[i]
+		if i == len(e.IncludeChain)-1 {
+			buf.WriteString(" (included from ")
+		} else {
+			buf.WriteString(", ")
+		}
+		if step.Filename != "" {
+			buf.WriteString(step.Filename)
+			buf.WriteByte(':')
+		}
+		fmt.Fprintf(&buf, "%d:%d", step.Position.Line, step.Position.Col)
+	}
+	if len(e.IncludeChain) > 0 {
+		buf.WriteByte(')')
+	}
+	return buf.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors lists the errors found when decoding with
+// DecodeOptions.Tolerant.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors, for use with errors.Is and errors.As.
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
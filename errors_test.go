@@ -0,0 +1,159 @@
+package ssh_config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parseErrorTests = []struct {
+	in    string
+	cause error
+	pos   Position
+	line  string
+}{
+	{"Host *\n  Port 22\nMatch bogus x\n", ErrInvalidMatch, Position{3, 7}, "Match bogus x"},
+	{"Match host\n", ErrInvalidMatch, Position{1, 7}, "Match host"},
+	{"Match all host x\r\n", ErrInvalidMatch, Position{1, 7}, "Match all host x"},
+	{"Match host \"a\n", ErrSyntax, Position{1, 7}, "Match host \"a"},
+	{"Host \"a\n", ErrInvalidPattern, Position{1, 6}, "Host \"a"},
+	{"Host a\n\tMatch exec\n", ErrInvalidMatch, Position{2, 8}, "\tMatch exec"},
+}
+
+func TestParseError(t *testing.T) {
+	for _, tt := range parseErrorTests {
+		_, err := DecodeOptions{Name: "config"}.DecodeBytes([]byte(tt.in))
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("DecodeBytes(%q): expected *ParseError, got %v", tt.in, err)
+			continue
+		}
+		if !errors.Is(err, tt.cause) {
+			t.Errorf("DecodeBytes(%q): expected cause %v, got %v", tt.in, tt.cause, perr.Err)
+		}
+		if perr.Filename != "config" {
+			t.Errorf("DecodeBytes(%q): expected Filename %q, got %q", tt.in, "config", perr.Filename)
+		}
+		if perr.Position != tt.pos {
+			t.Errorf("DecodeBytes(%q): expected Position %v, got %v", tt.in, tt.pos, perr.Position)
+		}
+		if perr.Line != tt.line {
+			t.Errorf("DecodeBytes(%q): expected Line %q, got %q", tt.in, tt.line, perr.Line)
+		}
+	}
+}
+
+func TestParseErrorIncludeChain(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.conf": "Host a\n  Include b.conf\n",
+		"b.conf": "# comment\nMatch bogus x\n",
+	})
+	_, err := DecodeOptions{Name: "config", BaseDir: dir}.DecodeBytes([]byte("Include a.conf\n"))
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected *ParseError, got %v", err)
+	}
+	if want := dir + "/b.conf"; perr.Filename != want {
+		t.Errorf("expected Filename %q, got %q", want, perr.Filename)
+	}
+	if perr.Line != "Match bogus x" {
+		t.Errorf("expected Line %q, got %q", "Match bogus x", perr.Line)
+	}
+	want := []IncludeStep{
+		{Filename: "config", Position: Position{1, 1}},
+		{Filename: dir + "/a.conf", Position: Position{2, 3}},
+	}
+	if !reflect.DeepEqual(perr.IncludeChain, want) {
+		t.Errorf("expected IncludeChain %v, got %v", want, perr.IncludeChain)
+	}
+	wantMsg := dir + "/b.conf:2:7: Match keyword not supported: bogus (included from " + dir + "/a.conf:2:3, config:1:1)"
+	if err.Error() != wantMsg {
+		t.Errorf("wrong error:\ngot  %q\nwant %q", err.Error(), wantMsg)
+	}
+}
+
+func TestParseErrorInclude(t *testing.T) {
+	_, err := DecodeBytes([]byte("Include \"unterminated\n"))
+	if !errors.Is(err, ErrInclude) {
+		t.Errorf("expected ErrInclude, got %v", err)
+	}
+}
+
+func TestTolerant(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"inc.conf": "Bogus yes\nUser inc\n",
+	})
+	in := `Host a
+  Port 1
+  Bogus yes
+Match bogus x
+  User skipped
+Host "b
+  User skipped
+Include inc.conf missing/*.conf "unterminated
+Host c
+  Port 3
+  Include inc.conf
+`
+	c, err := DecodeOptions{Name: "config", BaseDir: dir, Tolerant: true, Strict: true}.DecodeBytes([]byte(in))
+	if c == nil {
+		t.Fatalf("expected a Config, got error %v", err)
+	}
+	var errs ParseErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ParseErrors, got %v", err)
+	}
+	want := []string{
+		"config:3:3: Bad configuration option: Bogus",
+		"config:4:7: Match keyword not supported: bogus",
+		"config:6:6: Invalid host pattern: unterminated quote",
+		"config:8:1: Error parsing Include directive: unterminated quote",
+		dir + "/inc.conf:1:1: Bad configuration option: Bogus (included from config:11:3)",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %d:\n%v", len(want), len(errs), err)
+	}
+	for i := range want {
+		if got := errs[i].Error(); got != want[i] {
+			t.Errorf("error %d:\ngot  %q\nwant %q", i, got, want[i])
+		}
+	}
+	if !errors.Is(err, ErrInvalidMatch) || !errors.Is(err, ErrUnknownKeyword) {
+		t.Errorf("expected errors.Is to find the causes of %v", err)
+	}
+
+	tests := []struct {
+		host, key, want string
+	}{
+		{"a", "Port", "1"},
+		{"a", "User", ""},
+		{"b", "User", ""},
+		{"c", "Port", "3"},
+		{"c", "User", "inc"},
+	}
+	for _, tt := range tests {
+		got, err := c.Get(tt.key, NewMatchContext(tt.host, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Get(%q, %q): got %q, want %q", tt.host, tt.key, got, tt.want)
+		}
+	}
+	if s := c.Blocks[3].String(); !strings.HasPrefix(s, "Host \"b\n") {
+		t.Errorf("expected invalid Host line to be kept, got:\n%s", s)
+	}
+}
+
+func TestTolerantNoErrors(t *testing.T) {
+	c, err := DecodeOptions{Tolerant: true}.DecodeBytes([]byte("Host a\n  Port 1\n"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c == nil {
+		t.Fatal("expected a Config")
+	}
+}
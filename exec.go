@@ -0,0 +1,107 @@
+package ssh_config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// DefaultExecTimeout is the time a ShellExecutor waits for a command if its
+// Timeout is zero.
+const DefaultExecTimeout = 10 * time.Second
+
+// CommandExecutor runs the commands of "Match exec" criteria. Implementations
+// can be used to sandbox or stub command execution.
+type CommandExecutor interface {
+	// Exec runs command and reports whether it exited with status zero. A
+	// non-nil error is returned if the command could not be run at all.
+	Exec(command string) (bool, error)
+}
+
+// ShellExecutor is a CommandExecutor that runs commands with "Shell -c".
+type ShellExecutor struct {
+	// Shell is the shell used to run commands. If empty, "/bin/sh" is used.
+	Shell string
+	// Timeout is the maximum time a command may run before it is killed and
+	// considered failed. If zero, DefaultExecTimeout is used.
+	Timeout time.Duration
+}
+
+// Exec runs command with "e.Shell -c command".
+func (e *ShellExecutor) Exec(command string) (bool, error) {
+	shell := e.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := exec.CommandContext(ctx, shell, "-c", command).Run()
+	if err == nil {
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("ssh_config: command %q timed out after %v", command, timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return false, err
+}
+
+// runExec expands the tokens in command, runs it with ctx.Executor and
+// reports whether it succeeded. Results are cached per expanded command for
+// the lifetime of ctx. Commands that could not be expanded or run are
+// considered to have failed.
+func (ctx *MatchContext) runExec(command string) bool {
+	expanded, err := percentExpand(command, ctx.tokens().values(commonTokens))
+	if err != nil {
+		return false
+	}
+	if ok, cached := ctx.execResults[expanded]; cached {
+		return ok
+	}
+	executor := ctx.Executor
+	if executor == nil {
+		executor = &ShellExecutor{}
+	}
+	ok, err := executor.Exec(expanded)
+	if err != nil {
+		ok = false
+	}
+	if ctx.execResults == nil {
+		ctx.execResults = make(map[string]bool)
+	}
+	ctx.execResults[expanded] = ok
+	return ok
+}
+
+// tokens returns the values of the percent tokens as far as they are known
+// from ctx.
+func (ctx *MatchContext) tokens() *Tokens {
+	t := localTokens(ctx.LocalUser, ctx.Environment)
+	t.Host = ctx.Host
+	t.OriginalHost = ctx.OriginalHost
+	t.Port = ctx.Port
+	if t.Port == "" {
+		t.Port = Default("Port")
+	}
+	t.RemoteUser = ctx.User
+	if t.RemoteUser == "" {
+		t.RemoteUser = ctx.LocalUser
+	}
+	t.HostKeyAlias = ctx.hostKeyAlias
+	if t.HostKeyAlias == "" {
+		t.HostKeyAlias = ctx.OriginalHost
+	}
+	if !isNone(ctx.proxyJump) {
+		t.ProxyJump = ctx.proxyJump
+	}
+	return t
+}
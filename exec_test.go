@@ -0,0 +1,124 @@
+package ssh_config
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubExecutor struct {
+	results map[string]bool
+	calls   []string
+}
+
+func (e *stubExecutor) Exec(command string) (bool, error) {
+	e.calls = append(e.calls, command)
+	return e.results[command], nil
+}
+
+func TestMatchExec(t *testing.T) {
+	for _, up := range []bool{true, false} {
+		exec := &stubExecutor{results: map[string]bool{"test -f ~/.vpn-up": up}}
+		us := &UserSettings{
+			userConfigFinder:   testConfigFinder("testdata/match-exec"),
+			systemConfigFinder: nullConfigFinder,
+			Executor:           exec,
+		}
+		want := "bastion"
+		if up {
+			want = "bastion-vpn"
+		}
+		val, err := us.GetStrict("web", "ProxyJump", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != want {
+			t.Errorf("vpn up %v: expected ProxyJump %q, got %q", up, want, val)
+		}
+	}
+}
+
+func TestMatchExecTokens(t *testing.T) {
+	exec := &stubExecutor{results: map[string]bool{"nc -z db1.example.com 22": true}}
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-exec"),
+		systemConfigFinder: nullConfigFinder,
+		Executor:           exec,
+	}
+	val, err := us.GetStrict("db1.example.com", "Port", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "2222" {
+		t.Errorf("expected Port 2222, got %q", val)
+	}
+
+	// The exec criterion must not run if a previous criterion failed.
+	exec.calls = nil
+	if _, err := us.GetStrict("web", "Port", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range exec.calls {
+		if strings.HasPrefix(c, "nc") {
+			t.Errorf("expected %q not to be run", c)
+		}
+	}
+}
+
+func TestMatchExecHomeDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config": "Match exec \"test -f %d/.vpn-up\"\n  Port 2222\n",
+	})
+	exec := &stubExecutor{results: map[string]bool{"test -f /home/alice/.vpn-up": true}}
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder(filepath.Join(dir, "config")),
+		systemConfigFinder: nullConfigFinder,
+		Executor:           exec,
+		HomeDir:            "/home/alice",
+	}
+	val, err := us.GetStrict("web", "Port", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "2222" {
+		t.Errorf("expected Port 2222, got %q (commands run: %q)", val, exec.calls)
+	}
+}
+
+func TestMatchExecCache(t *testing.T) {
+	cfg, err := DecodeBytes([]byte("Match exec \"check %h\"\n\tPort 2222\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec := &stubExecutor{results: map[string]bool{"check a": true}}
+	ctx := NewMatchContext("a", "")
+	ctx.Executor = exec
+	for i := 0; i < 3; i++ {
+		if !cfg.Blocks[1].Matches(ctx) {
+			t.Fatal("expected Match exec to match")
+		}
+	}
+	if len(exec.calls) != 1 {
+		t.Errorf("expected command to be run once, got %d calls", len(exec.calls))
+	}
+}
+
+func TestShellExecutor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no /bin/sh on windows")
+	}
+	e := &ShellExecutor{}
+	if ok, err := e.Exec("true"); !ok || err != nil {
+		t.Errorf("Exec(true): got %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := e.Exec("exit 3"); ok || err != nil {
+		t.Errorf("Exec(exit 3): got %v, %v, want false, nil", ok, err)
+	}
+	e.Timeout = 10 * time.Millisecond
+	if ok, err := e.Exec("sleep 5"); ok || err == nil {
+		t.Errorf("Exec(sleep 5): got %v, %v, want false and a timeout error", ok, err)
+	}
+}
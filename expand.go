@@ -0,0 +1,361 @@
+package ssh_config
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	osuser "os/user"
+	"strconv"
+	"strings"
+)
+
+// Tokens holds the values substituted for the percent tokens accepted by some
+// keywords, for example "%h" in "IdentityFile ~/.ssh/%h_key". See the TOKENS
+// section of the manpage for ssh_config.
+type Tokens struct {
+	Host               string // %h: remote host name
+	OriginalHost       string // %n: original remote host name, as given on the command line
+	Port               string // %p: remote port
+	RemoteUser         string // %r: remote user name
+	LocalUser          string // %u: local user name
+	HomeDir            string // %d: home directory of the local user
+	LocalHostname      string // %l: local host name, including the domain name
+	ShortLocalHostname string // %L: local host name without the domain name
+	UID                string // %i: numeric user ID of the local user
+	HostKeyAlias       string // %k: HostKeyAlias, or the original host name if unset
+	ProxyJump          string // %j: ProxyJump, or empty if unset
+	TunnelDevice       string // %T: tunnel device, "NONE" if empty
+
+	// The following tokens are only accepted by KnownHostsCommand.
+	KnownHostsHost string // %H: known_hosts host name or address being searched for
+	ConnReason     string // %I: reason for the lookup, e.g. "ADDRESS" or "HOSTNAME"
+	Fingerprint    string // %f: fingerprint of the server host key
+	HostKey        string // %K: base64 encoded server host key
+	HostKeyType    string // %t: type of the server host key
+}
+
+// The tokens accepted by the keywords that support percent expansion, "%%"
+// being accepted by all of them.
+const (
+	commonTokens     = "CdhijkLlnpru"
+	proxyTokens      = "hnpr"
+	knownHostsTokens = commonTokens + "fHIKt"
+)
+
+var keywordTokens = map[string]string{
+	strings.ToLower("CertificateFile"):    commonTokens,
+	strings.ToLower("ControlPath"):        commonTokens,
+	strings.ToLower("HostName"):           "h",
+	strings.ToLower("IdentityAgent"):      commonTokens,
+	strings.ToLower("IdentityFile"):       commonTokens,
+	strings.ToLower("KnownHostsCommand"):  knownHostsTokens,
+	strings.ToLower("LocalCommand"):       commonTokens + "T",
+	strings.ToLower("LocalForward"):       commonTokens,
+	strings.ToLower("ProxyCommand"):       proxyTokens,
+	strings.ToLower("ProxyJump"):          proxyTokens,
+	strings.ToLower("RemoteCommand"):      commonTokens,
+	strings.ToLower("RemoteForward"):      commonTokens,
+	strings.ToLower("RevokedHostKeys"):    commonTokens,
+	strings.ToLower("UserKnownHostsFile"): commonTokens,
+}
+
+// SupportsTokens reports whether percent tokens are expanded in the values of
+// key.
+func SupportsTokens(key string) bool {
+	_, ok := keywordTokens[strings.ToLower(key)]
+	return ok
+}
+
+// ExpandTokens expands the percent tokens in value, which is a value for key,
+// with the values from t. Only the tokens that OpenSSH accepts for key are
+// expanded; an error is returned if value contains any other token. If key
+// does not support percent expansion, value is returned unchanged. A nil t is
+// treated like an empty Tokens.
+func ExpandTokens(key, value string, t *Tokens) (string, error) {
+	allowed, ok := keywordTokens[strings.ToLower(key)]
+	if !ok {
+		return value, nil
+	}
+	expanded, err := percentExpand(value, t.values(allowed))
+	if err != nil {
+		return "", fmt.Errorf("ssh_config: %s: %v", key, err)
+	}
+	return expanded, nil
+}
+
+// ConnectionHash returns the value of the %C token: the SHA1 hash of the local
+// host name, remote host name, port, remote user and ProxyJump, hex encoded.
+func (t *Tokens) ConnectionHash() string {
+	h := sha1.New()
+	for _, s := range []string{t.LocalHostname, t.Host, t.Port, t.RemoteUser, t.ProxyJump} {
+		h.Write([]byte(s))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// values returns the values of the tokens in allowed. If t is nil, they are
+// those of an empty Tokens.
+func (t *Tokens) values(allowed string) map[byte]string {
+	if t == nil {
+		t = &Tokens{}
+	}
+	m := make(map[byte]string, len(allowed))
+	for i := 0; i < len(allowed); i++ {
+		var v string
+		switch c := allowed[i]; c {
+		case 'C':
+			v = t.ConnectionHash()
+		case 'd':
+			v = t.HomeDir
+		case 'f':
+			v = t.Fingerprint
+		case 'H':
+			v = t.KnownHostsHost
+		case 'h':
+			v = t.Host
+		case 'I':
+			v = t.ConnReason
+		case 'i':
+			v = t.UID
+		case 'j':
+			v = t.ProxyJump
+		case 'K':
+			v = t.HostKey
+		case 'k':
+			v = t.HostKeyAlias
+		case 'L':
+			v = t.ShortLocalHostname
+		case 'l':
+			v = t.LocalHostname
+		case 'n':
+			v = t.OriginalHost
+		case 'p':
+			v = t.Port
+		case 'r':
+			v = t.RemoteUser
+		case 'T':
+			v = t.TunnelDevice
+			if v == "" {
+				v = "NONE"
+			}
+		case 't':
+			v = t.HostKeyType
+		case 'u':
+			v = t.LocalUser
+		}
+		m[allowed[i]] = v
+	}
+	return m
+}
+
+// localTokens returns Tokens with the values that describe the local machine
+// and user filled in. The home directory of the user is taken from env, or
+// from the environment of this process if env is nil.
+func localTokens(localUser string, env Environment) *Tokens {
+	if env == nil {
+		env = osEnvironment{}
+	}
+	hostname, _ := os.Hostname()
+	short, _, _ := strings.Cut(hostname, ".")
+	home, _ := env.HomeDir("")
+	return &Tokens{
+		LocalUser:          localUser,
+		HomeDir:            home,
+		LocalHostname:      hostname,
+		ShortLocalHostname: short,
+		UID:                strconv.Itoa(os.Getuid()),
+	}
+}
+
+// percentExpand replaces the %-tokens in s with their values from tokens. The
+// sequence "%%" is replaced by a literal "%". An error is returned if s
+// contains a token that is not present in tokens.
+func percentExpand(s string, tokens map[byte]string) (string, error) {
+	return expand(s, tokens, nil)
+}
+
+// expand replaces the %-tokens in s with their values from tokens, and
+// "${VAR}" with the value of the environment variable VAR from env, in a
+// single pass like OpenSSH does. If tokens is nil, %-tokens are left alone; if
+// env is nil, environment variables are.
+func expand(s string, tokens map[byte]string, env Environment) (string, error) {
+	if !strings.ContainsAny(s, "%$") {
+		return s, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%' && tokens != nil:
+			i++
+			if i >= len(s) {
+				return "", fmt.Errorf("invalid trailing %% in %q", s)
+			}
+			if s[i] == '%' {
+				buf.WriteByte('%')
+				continue
+			}
+			v, ok := tokens[s[i]]
+			if !ok {
+				return "", fmt.Errorf("unknown token %%%c in %q", s[i], s)
+			}
+			buf.WriteString(v)
+		case s[i] == '$' && env != nil && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated environment variable in %q", s)
+			}
+			name := s[i+2 : i+2+end]
+			if name == "" {
+				return "", fmt.Errorf("empty environment variable name in %q", s)
+			}
+			v, ok := env.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable ${%s} is not set", name)
+			}
+			buf.WriteString(v)
+			i += 2 + end
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String(), nil
+}
+
+// Environment provides the environment variables and home directories used
+// to expand "${VAR}" and "~" in keyword values.
+type Environment interface {
+	// LookupEnv returns the value of the environment variable key and
+	// whether it is set.
+	LookupEnv(key string) (string, bool)
+	// HomeDir returns the home directory of the named user, or of the
+	// current user if name is empty.
+	HomeDir(name string) (string, error)
+}
+
+// osEnvironment is the default Environment, backed by the environment of
+// this process and the user database.
+type osEnvironment struct{}
+
+func (osEnvironment) LookupEnv(key string) (string, bool) {
+	return os.LookupEnv(key)
+}
+
+func (osEnvironment) HomeDir(name string) (string, error) {
+	if name == "" {
+		return homedir(), nil
+	}
+	u, err := osuser.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.HomeDir, nil
+}
+
+// Keywords whose values may contain environment variables.
+var envKeywords = map[string]bool{
+	strings.ToLower("CertificateFile"):    true,
+	strings.ToLower("ControlPath"):        true,
+	strings.ToLower("IdentityAgent"):      true,
+	strings.ToLower("IdentityFile"):       true,
+	strings.ToLower("KnownHostsCommand"):  true,
+	strings.ToLower("UserKnownHostsFile"): true,
+}
+
+// Keywords whose values are paths that may start with "~". The values of the
+// keywords mapped to true are space-separated lists of paths.
+var tildeKeywords = map[string]bool{
+	strings.ToLower("CertificateFile"):      false,
+	strings.ToLower("ControlPath"):          false,
+	strings.ToLower("GlobalKnownHostsFile"): true,
+	strings.ToLower("IdentityAgent"):        false,
+	strings.ToLower("IdentityFile"):         false,
+	strings.ToLower("RevokedHostKeys"):      false,
+	strings.ToLower("UserKnownHostsFile"):   true,
+}
+
+// ExpandEnv expands the environment variables ("${VAR}") in value, which is a
+// value for key, using env. If env is nil, the environment of this process is
+// used. An error is returned if a variable is not set. If key does not support
+// environment variables, value is returned unchanged.
+func ExpandEnv(key, value string, env Environment) (string, error) {
+	if !envKeywords[strings.ToLower(key)] {
+		return value, nil
+	}
+	if env == nil {
+		env = osEnvironment{}
+	}
+	expanded, err := expand(value, nil, env)
+	if err != nil {
+		return "", fmt.Errorf("ssh_config: %s: %v", key, err)
+	}
+	return expanded, nil
+}
+
+// ExpandTilde replaces a leading "~" or "~user" in value, which is a value for
+// key, with the home directory of the current or the named user from env. If
+// env is nil, the user database of this system is used. If key is not a path
+// keyword, value is returned unchanged.
+func ExpandTilde(key, value string, env Environment) (string, error) {
+	list, ok := tildeKeywords[strings.ToLower(key)]
+	if !ok {
+		return value, nil
+	}
+	if env == nil {
+		env = osEnvironment{}
+	}
+	if !list {
+		return expandTilde(key, value, env)
+	}
+	paths := strings.Fields(value)
+	for i := range paths {
+		var err error
+		if paths[i], err = expandTilde(key, paths[i], env); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(paths, " "), nil
+}
+
+func expandTilde(key, path string, env Environment) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	name, rest, hasSlash := strings.Cut(path[1:], "/")
+	home, err := env.HomeDir(name)
+	if err != nil {
+		return "", fmt.Errorf("ssh_config: %s: cannot expand %q: %v", key, path, err)
+	}
+	if !hasSlash {
+		return home, nil
+	}
+	return strings.TrimSuffix(home, "/") + "/" + rest, nil
+}
+
+// Expand expands value, which is a value for key, the way ssh does: a leading
+// "~" is expanded first (see ExpandTilde), then environment variables and
+// percent tokens (see ExpandEnv and ExpandTokens). If env is nil, the
+// environment of this process is used; a nil t is treated like an empty
+// Tokens.
+func Expand(key, value string, t *Tokens, env Environment) (string, error) {
+	if env == nil {
+		env = osEnvironment{}
+	}
+	value, err := ExpandTilde(key, value, env)
+	if err != nil {
+		return "", err
+	}
+	lkey := strings.ToLower(key)
+	var tokens map[byte]string
+	if allowed, ok := keywordTokens[lkey]; ok {
+		tokens = t.values(allowed)
+	}
+	if !envKeywords[lkey] {
+		env = nil
+	}
+	expanded, err := expand(value, tokens, env)
+	if err != nil {
+		return "", fmt.Errorf("ssh_config: %s: %v", key, err)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,230 @@
+package ssh_config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testTokens = &Tokens{
+	Host:               "example.com",
+	OriginalHost:       "example",
+	Port:               "22",
+	RemoteUser:         "root",
+	LocalUser:          "alice",
+	HomeDir:            "/home/alice",
+	LocalHostname:      "localhost",
+	ShortLocalHostname: "localhost",
+	UID:                "1000",
+	HostKeyAlias:       "example",
+}
+
+var expandTokensTests = []struct {
+	key  string
+	in   string
+	want string
+	err  bool
+}{
+	{"IdentityFile", "~/.ssh/%h_key", "~/.ssh/example.com_key", false},
+	{"identityfile", "%d/.ssh/%u/%r@%n:%p", "/home/alice/.ssh/alice/root@example:22", false},
+	{"ControlPath", "~/.ssh/cm-%C", "~/.ssh/cm-fac9b82700d16f82c9c1fb7950c78671f5166e3f", false},
+	{"ControlPath", "%i-%k-%L-%l-%j.", "1000-example-localhost-localhost-.", false},
+	{"ProxyCommand", "nc %h %p", "nc example.com 22", false},
+	{"ProxyCommand", "nc %h %d", "", true},
+	{"ProxyJump", "%r@bastion", "root@bastion", false},
+	{"HostName", "%h.internal", "example.com.internal", false},
+	{"HostName", "%p", "", true},
+	{"LocalCommand", "echo %T 100%%", "echo NONE 100%", false},
+	{"RemoteCommand", "echo %T", "", true},
+	{"UserKnownHostsFile", "%d/known_hosts_%", "", true},
+	{"KnownHostsCommand", "lookup %H %t", "lookup  ", false},
+	{"Port", "%h", "%h", false},
+}
+
+func TestExpandTokens(t *testing.T) {
+	for _, tt := range expandTokensTests {
+		got, err := ExpandTokens(tt.key, tt.in, testTokens)
+		if (err != nil) != tt.err {
+			t.Errorf("ExpandTokens(%q, %q): got err %v, want err %v", tt.key, tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ExpandTokens(%q, %q): got %q, want %q", tt.key, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTokensNil(t *testing.T) {
+	for in, want := range map[string]string{"~/.ssh/id": "~/.ssh/id", "%h-%T": "-NONE"} {
+		got, err := ExpandTokens("LocalCommand", in, nil)
+		if err != nil {
+			t.Errorf("ExpandTokens(%q, nil): %v", in, err)
+		} else if got != want {
+			t.Errorf("ExpandTokens(%q, nil): got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestConnectionHash(t *testing.T) {
+	tokens := *testTokens
+	tokens.ProxyJump = "jump"
+	if got, want := tokens.ConnectionHash(), "c29be79e4a1c8b9a1beaa03690c787cb135a62cc"; got != want {
+		t.Errorf("ConnectionHash: got %q, want %q", got, want)
+	}
+}
+
+func TestResolvedConfigExpand(t *testing.T) {
+	us := &UserSettings{
+		Environment:        testEnv,
+		userConfigFinder:   testConfigFinder("testdata/tokens"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	rc, err := us.Resolve("db1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.Get("HostName"); got != "db1.internal" {
+		t.Errorf("expected HostName %q, got %q", "db1.internal", got)
+	}
+	ids, err := rc.ExpandAll("IdentityFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/home/alice/.ssh/admin@db1.internal_key", "/home/alice/.ssh/db1_key"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected IdentityFile %q, got %q", want, ids)
+	}
+	cert, err := rc.Expand("CertificateFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert != "/keys/db1-cert.pub" {
+		t.Errorf("expected CertificateFile %q, got %q", "/keys/db1-cert.pub", cert)
+	}
+	cmd, err := rc.Expand("ProxyCommand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != "nc db1.internal 2222" {
+		t.Errorf("expected ProxyCommand %q, got %q", "nc db1.internal 2222", cmd)
+	}
+
+	rc, err = us.Resolve("bad", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.Expand("ProxyCommand"); err == nil {
+		t.Error("expected error expanding ProxyCommand, got nil")
+	}
+
+	rc, err = us.Resolve("noenv", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rc.Expand("CertificateFile"); err == nil {
+		t.Error("expected error expanding CertificateFile, got nil")
+	}
+}
+
+// stubEnv is an Environment with fixed variables and home directories.
+type stubEnv struct {
+	vars  map[string]string
+	homes map[string]string
+}
+
+func (e stubEnv) LookupEnv(key string) (string, bool) {
+	v, ok := e.vars[key]
+	return v, ok
+}
+
+func (e stubEnv) HomeDir(name string) (string, error) {
+	home, ok := e.homes[name]
+	if !ok {
+		return "", errors.New("unknown user")
+	}
+	return home, nil
+}
+
+var testEnv = stubEnv{
+	vars: map[string]string{"KEYS": "/keys", "EMPTY": "", "PCT": "%h"},
+	homes: map[string]string{
+		"":    "/home/alice",
+		"bob": "/home/bob/",
+	},
+}
+
+var expandTests = []struct {
+	key  string
+	in   string
+	want string
+	err  bool
+}{
+	{"IdentityFile", "~/.ssh/%h_key", "/home/alice/.ssh/example.com_key", false},
+	{"IdentityFile", "~", "/home/alice", false},
+	{"IdentityFile", "~bob/.ssh/id", "/home/bob/.ssh/id", false},
+	{"IdentityFile", "~carol/.ssh/id", "", true},
+	{"IdentityFile", "/etc/~/id", "/etc/~/id", false},
+	{"IdentityFile", "${KEYS}/%n", "/keys/example", false},
+	{"IdentityFile", "${EMPTY}id", "id", false},
+	{"IdentityFile", "${UNSET}/id", "", true},
+	{"IdentityFile", "${KEYS", "", true},
+	{"IdentityFile", "${}", "", true},
+	{"IdentityFile", "$KEYS/id", "$KEYS/id", false},
+	// environment values are not expanded again
+	{"ControlPath", "${PCT}", "%h", false},
+	{"UserKnownHostsFile", "~/.ssh/known_hosts ~bob/known_hosts", "/home/alice/.ssh/known_hosts /home/bob/known_hosts", false},
+	{"GlobalKnownHostsFile", "~/known_hosts ${KEYS}", "/home/alice/known_hosts ${KEYS}", false},
+	{"KnownHostsCommand", "~/bin/lookup ${KEYS}", "~/bin/lookup /keys", false},
+	{"ProxyCommand", "~/bin/nc ${KEYS} %h", "~/bin/nc ${KEYS} example.com", false},
+	{"Port", "${KEYS}", "${KEYS}", false},
+}
+
+func TestExpand(t *testing.T) {
+	for _, tt := range expandTests {
+		got, err := Expand(tt.key, tt.in, testTokens, testEnv)
+		if (err != nil) != tt.err {
+			t.Errorf("Expand(%q, %q): got err %v, want err %v", tt.key, tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Expand(%q, %q): got %q, want %q", tt.key, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandNilTokens(t *testing.T) {
+	got, err := Expand("IdentityFile", "~/.ssh/id_%h", nil, testEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/home/alice/.ssh/id_"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	got, err := ExpandEnv("IdentityFile", "${KEYS}/%h", testEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/keys/%h" {
+		t.Errorf("got %q, want %q", got, "/keys/%h")
+	}
+	_, err = ExpandEnv("IdentityFile", "${UNSET}", testEnv)
+	if err == nil || err.Error() != "ssh_config: IdentityFile: environment variable ${UNSET} is not set" {
+		t.Errorf("wrong error: got %v", err)
+	}
+}
+
+func TestExpandTilde(t *testing.T) {
+	got, err := ExpandTilde("IdentityFile", "~bob/${KEYS}", testEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/home/bob/${KEYS}" {
+		t.Errorf("got %q, want %q", got, "/home/bob/${KEYS}")
+	}
+	if got, _ := ExpandTilde("ProxyCommand", "~/nc", testEnv); got != "~/nc" {
+		t.Errorf("expected ProxyCommand to be unchanged, got %q", got)
+	}
+}
@@ -0,0 +1,263 @@
+package ssh_config
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatOptions control how Format prints a config.
+type FormatOptions struct {
+	// Indent is the indentation of the lines in Host and Match blocks. If
+	// empty, two spaces are used.
+	Indent string
+	// Equals separates keys from their values with "=" rather than a space.
+	// Host and Match lines always use a space.
+	Equals bool
+	// SortKeys sorts the keys in each block alphabetically. Keys are only
+	// moved within groups of lines that are not separated by empty lines,
+	// Include directives or IgnoreUnknown, which only applies to the keys
+	// after it. Comment lines move along with the key below them, and keys
+	// that are given more than once keep their order, so the config has the
+	// same meaning as before.
+	SortKeys bool
+}
+
+// fmtLine is a line printed by Format.
+type fmtLine struct {
+	text       string // the line without its trailing comment
+	comment    string // the trailing comment, without the '#'
+	hasComment bool
+	blank      bool
+}
+
+// fmtEntry is a group of lines in a block that is sorted as a unit: a key or
+// an Include with the comment lines above it, an empty line, or comment lines
+// that are not above a key.
+type fmtEntry struct {
+	lines []fmtLine
+	// key is the lower-cased key to sort by, or "" if the entry must not
+	// be moved.
+	key   string
+	blank bool
+}
+
+// Format returns c printed in a canonical form: Host and Match lines start at
+// the beginning of the line and the lines in their blocks are indented by
+// opts.Indent, keywords are spelled as in the ssh_config documentation, e.g.
+// HostName for hostname, blocks are separated by exactly one empty line, and
+// the trailing comments of consecutive lines are aligned. Comments and the
+// order of blocks and keys are kept, unless opts.SortKeys is set.
+//
+// Included files are not printed, as by String. Formatting the result again
+// does not change it.
+func Format(c *Config, opts FormatOptions) []byte {
+	indent := opts.Indent
+	if indent == "" {
+		indent = defaultStyle.indent
+	}
+	var lines, lead []fmtLine
+	for i, block := range c.Blocks {
+		nodes := block.GetNodes()
+		var attached []Node
+		if i < len(c.Blocks)-1 {
+			// Comment lines directly above the next block are printed
+			// with it.
+			j := attachedComments(nodes)
+			nodes, attached = nodes[:j], nodes[j:]
+		}
+		section := lead
+		if i > 0 {
+			section = append(section, formatHeader(block))
+			section = append(section, formatNodes(nodes, indent, opts)...)
+		} else {
+			section = formatNodes(nodes, "", opts)
+		}
+		lead = nil
+		for _, node := range attached {
+			lead = append(lead, formatComment(node.(*Empty), ""))
+		}
+		if len(section) > 0 {
+			if len(lines) > 0 {
+				lines = append(lines, fmtLine{blank: true})
+			}
+			lines = append(lines, section...)
+		}
+	}
+	alignComments(lines)
+
+	eol := c.editStyle().eol
+	var buf bytes.Buffer
+	if c.bom {
+		buf.WriteString(byteOrderMark)
+	}
+	for _, l := range lines {
+		buf.WriteString(l.text)
+		if l.hasComment {
+			buf.WriteByte('#')
+			buf.WriteString(l.comment)
+		}
+		buf.WriteString(eol)
+	}
+	return buf.Bytes()
+}
+
+// formatHeader returns the Host or Match line of block.
+func formatHeader(block Block) fmtLine {
+	var buf strings.Builder
+	var bd *BlockData
+	switch b := block.(type) {
+	case *Host:
+		bd = b.BlockData
+		buf.WriteString("Host ")
+		if bd.isInvalid() {
+			buf.WriteString(bd.value)
+			break
+		}
+		for i, pat := range b.Patterns {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			str := pat.String()
+			if strings.ContainsAny(str, " \t") {
+				str = `"` + str + `"`
+			}
+			buf.WriteString(str)
+		}
+	case *Match:
+		bd = b.BlockData
+		buf.WriteString("Match ")
+		if bd.isInvalid() {
+			buf.WriteString(bd.value)
+			break
+		}
+		for i, c := range b.Criteria {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			c := *c
+			c.spelling = c.Keyword
+			buf.WriteString(c.String())
+		}
+	default:
+		return fmtLine{text: strings.TrimSpace(block.String())}
+	}
+	return trailingComment(buf.String(), bd.EOLComment)
+}
+
+// formatNodes returns the lines of the nodes of a block, indented by indent.
+func formatNodes(nodes []Node, indent string, opts FormatOptions) []fmtLine {
+	sep := " "
+	if opts.Equals {
+		sep = "="
+	}
+	var entries []fmtEntry
+	var comments []fmtLine
+	for _, node := range nodes {
+		var line fmtLine
+		key := ""
+		switch t := node.(type) {
+		case *Empty:
+			if isBlank(t) {
+				if len(comments) > 0 {
+					entries = append(entries, fmtEntry{lines: comments})
+					comments = nil
+				}
+				entries = append(entries, fmtEntry{blank: true})
+			} else {
+				comments = append(comments, formatComment(t, indent))
+			}
+			continue
+		case *KV:
+			name := t.Key
+			if s := knownKeywords[strings.ToLower(name)]; s != "" {
+				name = s
+			}
+			line = trailingComment(indent+name+sep+t.Value, t.Comment)
+			key = strings.ToLower(name)
+			if key == "ignoreunknown" {
+				key = ""
+			}
+		case *Include:
+			line = trailingComment(indent+"Include"+sep+strings.Join(t.directives, " "), t.Comment)
+		default:
+			line = fmtLine{text: indent + strings.TrimSpace(node.String())}
+		}
+		entries = append(entries, fmtEntry{lines: append(comments, line), key: key})
+		comments = nil
+	}
+	if len(comments) > 0 {
+		entries = append(entries, fmtEntry{lines: comments})
+	}
+	if opts.SortKeys {
+		sortEntries(entries)
+	}
+
+	var lines []fmtLine
+	for _, e := range entries {
+		if e.blank {
+			// Drop empty lines at the start and the end of the block,
+			// and repeated ones.
+			if len(lines) == 0 || lines[len(lines)-1].blank {
+				continue
+			}
+			lines = append(lines, fmtLine{blank: true})
+			continue
+		}
+		lines = append(lines, e.lines...)
+	}
+	for len(lines) > 0 && lines[len(lines)-1].blank {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// sortEntries sorts the runs of entries with keys by key, keeping the order
+// of entries with the same key.
+func sortEntries(entries []fmtEntry) {
+	for i := 0; i < len(entries); {
+		j := i
+		for j < len(entries) && entries[j].key != "" {
+			j++
+		}
+		run := entries[i:j]
+		sort.SliceStable(run, func(a, b int) bool {
+			return run[a].key < run[b].key
+		})
+		i = j + 1
+	}
+}
+
+// formatComment returns the comment line e, indented by indent.
+func formatComment(e *Empty, indent string) fmtLine {
+	return fmtLine{text: indent + "#" + strings.TrimRight(e.Comment, " \t")}
+}
+
+func trailingComment(text, comment string) fmtLine {
+	if comment == "" {
+		return fmtLine{text: text}
+	}
+	return fmtLine{text: text, comment: strings.TrimRight(comment, " \t"), hasComment: true}
+}
+
+// alignComments pads the lines with trailing comments so that the comments
+// of consecutive lines start in the same column, one space after the longest
+// of those lines.
+func alignComments(lines []fmtLine) {
+	for i := 0; i < len(lines); {
+		j, width := i, 0
+		for ; j < len(lines) && !lines[j].blank; j++ {
+			if n := utf8.RuneCountInString(lines[j].text); lines[j].hasComment && n > width {
+				width = n
+			}
+		}
+		for k := i; k < j; k++ {
+			if lines[k].hasComment {
+				n := utf8.RuneCountInString(lines[k].text)
+				lines[k].text += strings.Repeat(" ", width-n+1)
+			}
+		}
+		i = j + 1
+	}
+}
@@ -0,0 +1,146 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var formatTests = []struct {
+	name string
+	in   string
+	opts FormatOptions
+	want string
+}{
+	{
+		"indentation and casing",
+		"compression yes\nhost a\nhostname a.example.com\n\t\tuser = x\n",
+		FormatOptions{},
+		"Compression yes\n\nHost a\n  HostName a.example.com\n  User x\n",
+	},
+	{
+		"custom indent and equals",
+		"Host a\n  HostName a.example.com\n  include other.conf\n",
+		FormatOptions{Indent: "\t", Equals: true},
+		"Host a\n\tHostName=a.example.com\n\tInclude=other.conf\n",
+	},
+	{
+		"blank lines",
+		"\n\n# header\n\n\nHost a\n\n  User a\n\n\n  Port 22\n\n\n\nHost b\n  User b\n\n\n",
+		FormatOptions{},
+		"# header\n\nHost a\n  User a\n\n  Port 22\n\nHost b\n  User b\n",
+	},
+	{
+		"attached comments",
+		"Host a\n  User a\n    # about b\n# more\nHost b\n  User b\n",
+		FormatOptions{},
+		"Host a\n  User a\n\n# about b\n# more\nHost b\n  User b\n",
+	},
+	{
+		"aligned comments",
+		"Host a b # hosts\n  HostName a.example.com   # the host\n  Port 22 #port\n\n  User x  # user   \n",
+		FormatOptions{},
+		"Host a b                 # hosts\n  HostName a.example.com # the host\n  Port 22                #port\n\n  User x # user\n",
+	},
+	{
+		"match",
+		"MATCH Host \"x y\" EXEC true # m\n  user y\n",
+		FormatOptions{},
+		"Match host \"x y\" exec true # m\n  User y\n",
+	},
+	{
+		"sort keys",
+		"Host a\n  User a\n  # the key\n  IdentityFile k2\n  Port 22\n  identityfile k1\n\n  ProxyJump j\n  Include x.conf\n  HostName h\n  Compression yes\n",
+		FormatOptions{SortKeys: true},
+		"Host a\n  # the key\n  IdentityFile k2\n  IdentityFile k1\n  Port 22\n  User a\n\n  ProxyJump j\n  Include x.conf\n  Compression yes\n  HostName h\n",
+	},
+	{
+		"sort keys after IgnoreUnknown",
+		"Host a\n  User a\n  IgnoreUnknown AppleKeychain\n  Port 22\n  AppleKeychain yes\n",
+		FormatOptions{SortKeys: true},
+		"Host a\n  User a\n  IgnoreUnknown AppleKeychain\n  AppleKeychain yes\n  Port 22\n",
+	},
+	{
+		"crlf and bom",
+		"\ufeffHost a\r\nuser x\r\n",
+		FormatOptions{},
+		"\ufeffHost a\r\n  User x\r\n",
+	},
+	{
+		"empty",
+		"\n\n",
+		FormatOptions{},
+		"",
+	},
+}
+
+func TestFormat(t *testing.T) {
+	for _, tt := range formatTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := DecodeBytes([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(Format(cfg, tt.opts))
+			if got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+			again, err := DecodeBytes([]byte(got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := string(Format(again, tt.opts)); s != got {
+				t.Errorf("formatting again: got %q", s)
+			}
+			// Keys accepted by ssh before formatting still are.
+			if _, err := (DecodeOptions{Strict: true}).DecodeBytes([]byte(tt.in)); err == nil {
+				if _, err := (DecodeOptions{Strict: true}).DecodeBytes([]byte(got)); err != nil {
+					t.Errorf("strict decoding of the result: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestFormatTestdata checks that formatting the test configs is idempotent
+// and does not change their meaning.
+func TestFormatTestdata(t *testing.T) {
+	files, err := filepath.Glob("testdata/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"", "foo", "bar", "wildcard", "example.com", "dos", "eqsign", "random"}
+	keys := []string{"HostName", "User", "Port", "IdentityFile", "ProxyCommand", "LocalForward"}
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			continue // a directory
+		}
+		cfg, err := DecodeBytes(b)
+		if err != nil {
+			continue
+		}
+		for _, opts := range []FormatOptions{{}, {Equals: true, SortKeys: true}} {
+			out := Format(cfg, opts)
+			formatted, err := DecodeBytes(out)
+			if err != nil {
+				t.Errorf("%s: %v\n%s", name, err, out)
+				continue
+			}
+			if again := Format(formatted, opts); string(again) != string(out) {
+				t.Errorf("%s: formatting again: got\n%s\nwant\n%s", name, again, out)
+			}
+			for _, host := range hosts {
+				for _, key := range keys {
+					ctx := &MatchContext{Host: host, OriginalHost: host}
+					want, _ := cfg.GetAll(key, ctx)
+					got, _ := formatted.GetAll(key, ctx)
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("%s: GetAll(%q, %q): got %q, want %q", name, host, key, got, want)
+					}
+				}
+			}
+		}
+	}
+}
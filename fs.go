@@ -0,0 +1,99 @@
+package ssh_config
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileSystem reads configuration files and the files of Include directives,
+// either from the local file system or from an fs.FS.
+type fileSystem struct {
+	// fsys is the file system to read from, or nil for the local file
+	// system. Absolute paths are relative to the root of fsys.
+	fsys fs.FS
+	// home is the home directory of the user, or empty for the home
+	// directory of the current user.
+	home string
+	// env looks up the home directories of other users, for "~user" in
+	// Include paths. If nil, the user database of this system is used.
+	env Environment
+}
+
+// osFileSystem reads from the local file system on behalf of the current user.
+var osFileSystem = &fileSystem{}
+
+// name returns the name of the file with the given path in f.fsys.
+func (f *fileSystem) name(p string) string {
+	name := strings.TrimPrefix(path.Clean(filepath.ToSlash(p)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// localPath returns p if f reads from the local file system, so that a file
+// read from p can be written back, and "" otherwise.
+func (f *fileSystem) localPath(p string) string {
+	if f.fsys != nil {
+		return ""
+	}
+	return p
+}
+
+func (f *fileSystem) readFile(p string) ([]byte, error) {
+	if f.fsys == nil {
+		return os.ReadFile(p)
+	}
+	return fs.ReadFile(f.fsys, f.name(p))
+}
+
+// glob returns the paths matching pattern, like filepath.Glob.
+func (f *fileSystem) glob(pattern string) ([]string, error) {
+	if f.fsys == nil {
+		return filepath.Glob(pattern)
+	}
+	matches, err := fs.Glob(f.fsys, f.name(pattern))
+	if err != nil {
+		return nil, err
+	}
+	if filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "/") {
+		for i := range matches {
+			matches[i] = "/" + matches[i]
+		}
+	}
+	return matches, nil
+}
+
+// HomeDir returns the home directory of the named user, or of the user f reads
+// files for if name is empty. With LookupEnv, it makes f an Environment for
+// the tilde expansion of Include paths.
+func (f *fileSystem) HomeDir(name string) (string, error) {
+	if name == "" && f.home != "" {
+		return f.home, nil
+	}
+	if f.env == nil {
+		return osEnvironment{}.HomeDir(name)
+	}
+	return f.env.HomeDir(name)
+}
+
+func (f *fileSystem) LookupEnv(key string) (string, bool) {
+	if f.env == nil {
+		return os.LookupEnv(key)
+	}
+	return f.env.LookupEnv(key)
+}
+
+// DecodeFS reads the file with the given name from fsys into a Config. The
+// files of Include directives are read from fsys as well. Absolute paths, both
+// name and those of Include directives, are relative to the root of fsys, so
+// "/etc/ssh/ssh_config" is read as "etc/ssh/ssh_config". The file is decoded
+// as a user configuration file, so relative Include paths are relative to
+// home/.ssh; if home is empty, the home directory of the current user is used.
+// Use DecodeOptions to decode a system configuration file.
+func DecodeFS(fsys fs.FS, name, home string) (*Config, error) {
+	return DecodeOptions{FS: fsys, HomeDir: home}.DecodeFile(name)
+}
@@ -0,0 +1,109 @@
+package ssh_config
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+var testFS = fstest.MapFS{
+	"home/alice/.ssh/config": {Data: []byte(`Include config.d/*
+Include ~/.ssh/extra
+
+Match localuser alice
+	User alice-remote
+
+Host *
+	User nobody
+`)},
+	"home/alice/.ssh/config.d/web": {Data: []byte(`Host web
+	HostName web.example.com
+	IdentityFile ~/.ssh/web_key
+`)},
+	"home/alice/.ssh/extra": {Data: []byte(`Host db
+	Port 5432
+`)},
+	"etc/ssh/ssh_config": {Data: []byte(`Include ssh_config.d/*.conf
+
+Host *
+	Port 2222
+`)},
+	"etc/ssh/ssh_config.d/10-global.conf": {Data: []byte(`Host *
+	Compression yes
+`)},
+}
+
+func TestUserSettingsFS(t *testing.T) {
+	us := &UserSettings{
+		FS:        testFS,
+		HomeDir:   "/home/alice",
+		LocalUser: "alice",
+	}
+	tests := []struct {
+		alias string
+		key   string
+		want  string
+	}{
+		{"web", "HostName", "web.example.com"},
+		{"web", "User", "alice-remote"},
+		{"db", "Port", "5432"},
+		{"web", "Port", "2222"},
+		{"web", "Compression", "yes"},
+	}
+	for _, tt := range tests {
+		got, err := us.GetStrict(tt.alias, tt.key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("GetStrict(%q, %q): got %q, want %q", tt.alias, tt.key, got, tt.want)
+		}
+	}
+
+	rc, err := us.Resolve("web", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := rc.ExpandAll("IdentityFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/home/alice/.ssh/web_key"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected IdentityFile %q, got %q", want, ids)
+	}
+	if got := rc.Tokens().LocalUser; got != "alice" {
+		t.Errorf("expected local user %q, got %q", "alice", got)
+	}
+}
+
+func TestUserSettingsFSMissing(t *testing.T) {
+	us := &UserSettings{
+		FS:      fstest.MapFS{},
+		HomeDir: "/home/bob",
+	}
+	got, err := us.GetStrict("web", "Port", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "22" {
+		t.Errorf("expected default Port 22, got %q", got)
+	}
+}
+
+func TestDecodeFS(t *testing.T) {
+	c, err := DecodeFS(testFS, "/home/alice/.ssh/config", "/home/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Get("Port", NewMatchContext("db", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "5432" {
+		t.Errorf("expected Port 5432, got %q", got)
+	}
+
+	if _, err := DecodeFS(testFS, "home/alice/.ssh/nonexistent", "/home/alice"); err == nil {
+		t.Error("expected error decoding nonexistent file, got nil")
+	}
+}
@@ -1,18 +1,99 @@
-//go:build 1.18
-// +build 1.18
-
 package ssh_config
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 )
 
 func FuzzDecode(f *testing.F) {
 	f.Fuzz(func(t *testing.T, in []byte) {
 		_, err := Decode(bytes.NewReader(in))
-		if err != nil {
+		var perr *ParseError
+		if err != nil && !errors.As(err, &perr) {
 			t.Fatalf("decode %q: %v", string(in), err)
 		}
 	})
 }
+
+// matchPattern is match_pattern from match.c in OpenSSH, with the ASCII
+// lower-casing of match_pattern_list applied to both arguments if fold is
+// true. It serves as the reference for matchGlob.
+func matchPattern(s, pattern string, fold bool) bool {
+	if fold {
+		s, pattern = toLower(s), toLower(pattern)
+	}
+	return matchPatternC(s, pattern)
+}
+
+func matchPatternC(s, pattern string) bool {
+	for {
+		if pattern == "" {
+			return s == ""
+		}
+		if pattern[0] == '*' {
+			for pattern != "" && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			if pattern[0] != '?' {
+				for ; s != ""; s = s[1:] {
+					if s[0] == pattern[0] && matchPatternC(s[1:], pattern[1:]) {
+						return true
+					}
+				}
+				return false
+			}
+			for ; s != ""; s = s[1:] {
+				if matchPatternC(s, pattern) {
+					return true
+				}
+			}
+			return false
+		}
+		if s == "" {
+			return false
+		}
+		if pattern[0] != '?' && pattern[0] != s[0] {
+			return false
+		}
+		s, pattern = s[1:], pattern[1:]
+	}
+}
+
+func FuzzMatchGlob(f *testing.F) {
+	for _, tt := range matchGlobTests {
+		f.Add(tt.pattern, tt.s, tt.fold)
+	}
+	f.Fuzz(func(t *testing.T, pattern, s string, fold bool) {
+		if len(pattern) > 64 || len(s) > 64 {
+			// The reference implementation is exponential.
+			return
+		}
+		want := matchPattern(s, pattern, fold)
+		if got := matchGlob(pattern, s, fold); got != want {
+			t.Fatalf("matchGlob(%q, %q, %v): got %v, want %v", pattern, s, fold, got, want)
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, in := range roundTripTests {
+		f.Add([]byte(in))
+	}
+	f.Fuzz(func(t *testing.T, in []byte) {
+		if bytes.Contains(bytes.ToLower(in), []byte("include")) {
+			// Included files are read from the file system.
+			return
+		}
+		cfg, err := Decode(bytes.NewReader(in))
+		if err != nil {
+			return
+		}
+		if got := cfg.String(); got != string(in) {
+			t.Fatalf("round trip:\ngot  %q\nwant %q", got, in)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package ssh_config
+
+// matchGlob reports whether s matches pattern, in which '*' matches any
+// sequence of bytes, including an empty one, and '?' matches exactly one byte.
+// All other bytes match themselves; if fold is true, ASCII letters match
+// regardless of case. The semantics are those of match_pattern in OpenSSH,
+// which compares bytes rather than runes.
+func matchGlob(pattern, s string, fold bool) bool {
+	// When a '*' has been seen, star is the index of the rest of the pattern
+	// and next the index in s to try to match it at after a mismatch.
+	// Backtracking to the last '*' is sufficient: an earlier '*' could only
+	// absorb bytes that the last one can absorb as well.
+	star, next := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch c := pattern[p]; {
+			case c == '*':
+				p++
+				star, next = p, i
+				continue
+			case c == '?' || c == s[i] || fold && lower(c) == lower(s[i]):
+				p++
+				i++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		p, i = star, next
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// lower returns the lower case of the ASCII letter c, or c.
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// toLower returns s with ASCII letters mapped to lower case, as host names
+// are compared by matchGlob. Unlike strings.ToLower, it leaves other letters
+// alone.
+func toLower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if lower(s[i]) != s[i] {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				b[j] = lower(b[j])
+			}
+			return string(b)
+		}
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package ssh_config
+
+import "testing"
+
+var matchGlobTests = []struct {
+	pattern, s string
+	fold       bool
+	want       bool
+}{
+	{"", "", false, true},
+	{"", "a", false, false},
+	{"*", "", false, true},
+	{"*", "anything", false, true},
+	{"?", "", false, false},
+	{"?", "a", false, true},
+	{"?", "ab", false, false},
+	{"dhcp-??", "dhcp-1", false, false},
+	{"dhcp-??", "dhcp-12", false, true},
+	{"192.168.0.?", "192.168.0.", false, false},
+	{"192.168.0.?", "192.168.0.7", false, true},
+	{"*.example.com", "example.com", false, false},
+	{"*.example.com", "a.b.example.com", false, true},
+	{"a*b*c", "abc", false, true},
+	{"a*b*c", "aXbXbXc", false, true},
+	{"a*b*c", "aXbXcX", false, false},
+	{"**a", "a", false, true},
+	{"*?", "", false, false},
+	{"*?*", "x", false, true},
+	{"a.b", "aXb", false, false},
+	{"Web*", "web-1", true, true},
+	{"web*", "WEB-1", true, true},
+	{"web*", "WEB-1", false, false},
+	// Only ASCII letters are folded, and '?' matches a single byte.
+	{"Ä", "ä", true, false},
+	{"?", "ä", false, false},
+	{"??", "ä", false, true},
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tt := range matchGlobTests {
+		if got := matchGlob(tt.pattern, tt.s, tt.fold); got != tt.want {
+			t.Errorf("matchGlob(%q, %q, %v): got %v, want %v", tt.pattern, tt.s, tt.fold, got, tt.want)
+		}
+	}
+}
+
+func TestMatchGlobAllocs(t *testing.T) {
+	p, err := NewPattern("*.Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		p.matches("www.EXAMPLE.com")
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+}
+
+func TestToLower(t *testing.T) {
+	for in, want := range map[string]string{"": "", "abc": "abc", "AbC-1": "abc-1", "ÄB": "Äb"} {
+		if got := toLower(in); got != want {
+			t.Errorf("toLower(%q): got %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package ssh_config
+
+import (
+	"strings"
+)
+
+// hostIndex finds the blocks of a Config that may match a host without
+// matching the host against the patterns of every block.
+type hostIndex struct {
+	// literal maps host names, lower-cased with toLower, to the Host
+	// blocks that have the host name as one of their patterns, and no
+	// wildcard patterns, in order.
+	literal map[string][]int
+	// fallback lists the blocks that are checked for every host, in order:
+	// Match blocks, Host blocks with wildcard patterns, and blocks with
+	// Include directives.
+	fallback []int
+}
+
+// BuildIndex precomputes an index of the Host blocks of c and of the files
+// it includes, which Get, GetAll and UserSettings then use to find the blocks
+// that may match a host. Host blocks whose patterns are all literal host
+// names are looked up by name; all other blocks are still checked in order,
+// so the result of a lookup is the same as without the index.
+//
+// AddHost, RemoveBlock and MoveBlock update the index, but it is not updated
+// when c.Blocks is changed directly; call BuildIndex again afterwards.
+// BuildIndex must not be called concurrently with lookups in c.
+func (c *Config) BuildIndex() {
+	x := &hostIndex{literal: make(map[string][]int)}
+	for i, block := range c.Blocks {
+		names, ok := literalHosts(block)
+		if !ok {
+			x.fallback = append(x.fallback, i)
+			continue
+		}
+		for _, name := range names {
+			blocks := x.literal[name]
+			// A name may appear more than once in the same block.
+			if len(blocks) == 0 || blocks[len(blocks)-1] != i {
+				x.literal[name] = append(blocks, i)
+			}
+		}
+	}
+	c.index = x
+	for _, block := range c.Blocks {
+		for _, node := range block.GetNodes() {
+			if inc, ok := node.(*Include); ok {
+				inc.buildIndex()
+			}
+		}
+	}
+}
+
+func (inc *Include) buildIndex() {
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+	for _, cfg := range inc.files {
+		if cfg != nil {
+			cfg.BuildIndex()
+		}
+	}
+}
+
+// literalHosts returns the host names, lower-cased with toLower, that block
+// matches, if block is a Host block that only matches host names given
+// literally. Blocks with Include directives are never indexed by name,
+// because the resolver visits the included files even if the block does not
+// match.
+func literalHosts(block Block) ([]string, bool) {
+	h, ok := block.(*Host)
+	if !ok || h.implicit || h.isInvalid() {
+		return nil, false
+	}
+	for _, node := range h.Nodes {
+		if _, ok := node.(*Include); ok {
+			return nil, false
+		}
+	}
+	var names []string
+	for _, pat := range h.Patterns {
+		if pat.not {
+			// Negated patterns can only exclude a host, which Matches
+			// checks for the blocks found in the index.
+			continue
+		}
+		if strings.ContainsAny(pat.glob, "*?") {
+			return nil, false
+		}
+		names = append(names, toLower(pat.glob))
+	}
+	return names, len(names) > 0
+}
+
+// blockIter iterates over the indices of blocks in a Config, in order.
+type blockIter struct {
+	// i is the current block.
+	i int
+	// n is the number of blocks, when iterating over all of them.
+	n int
+	// a and b are the remaining blocks to merge, when using an index.
+	a, b    []int
+	indexed bool
+}
+
+func (it *blockIter) next() bool {
+	if !it.indexed {
+		it.i++
+		return it.i < it.n
+	}
+	switch {
+	case len(it.a) == 0 && len(it.b) == 0:
+		return false
+	case len(it.b) == 0 || len(it.a) > 0 && it.a[0] < it.b[0]:
+		it.i, it.a = it.a[0], it.a[1:]
+	default:
+		it.i, it.b = it.b[0], it.b[1:]
+	}
+	return true
+}
+
+// blocksFor returns an iterator over the blocks of c that may match ctx. It
+// uses the index of c if there is one and useIndex is true; callers must only
+// set useIndex if the host that Host blocks are matched against does not
+// change during the iteration.
+func (c *Config) blocksFor(ctx *MatchContext, useIndex bool) blockIter {
+	host := ctx.OriginalHost
+	if ctx.FinalPass {
+		host = ctx.Host
+	}
+	if c.index == nil || !useIndex {
+		return blockIter{i: -1, n: len(c.Blocks)}
+	}
+	return blockIter{
+		a:       c.index.literal[toLower(host)],
+		b:       c.index.fallback,
+		indexed: true,
+	}
+}
@@ -0,0 +1,137 @@
+package ssh_config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var indexTestConfig = `Host a
+  User a1
+Host *.example.com b
+  User wild
+Host B c !d
+  Port 2
+  User bc
+Host d
+  User d
+Match host a,c
+  Port 3
+Host c
+  Port 4
+  HostName c.example.com
+  User c
+Host e e
+  IdentityFile e1
+Host !e
+  IdentityFile not-e
+Host x.example.com
+  User x
+Host "Ä"
+  User umlaut
+Host é
+  Include inc.conf
+Host *
+  IdentityFile all
+`
+
+var indexTestHosts = []string{"a", "A", "b", "B", "c", "d", "e", "f", "x.example.com", "X.EXAMPLE.COM", "ä", "Ä", "é", ""}
+
+func TestBuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"inc.conf": "Host a f\n  Port 5\nHost *\n  IdentityFile inc\n",
+	})
+	opts := DecodeOptions{BaseDir: dir}
+	plain, err := opts.DecodeBytes([]byte(indexTestConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed, err := opts.DecodeBytes([]byte(indexTestConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed.BuildIndex()
+	for _, host := range indexTestHosts {
+		for _, key := range []string{"User", "Port", "HostName"} {
+			want, err := plain.Get(key, NewMatchContext(host, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := indexed.Get(key, NewMatchContext(host, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("Get(%q, %q): got %q with index, want %q", host, key, got, want)
+			}
+		}
+		want, err := plain.GetAll("IdentityFile", NewMatchContext(host, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := indexed.GetAll("IdentityFile", NewMatchContext(host, ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetAll(%q, IdentityFile): got %q with index, want %q", host, got, want)
+		}
+	}
+}
+
+func TestUserSettingsIndexHosts(t *testing.T) {
+	plain := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/conformance/first-value-wins"),
+		systemConfigFinder: nullConfigFinder,
+		HostResolver:       conformanceResolver,
+	}
+	indexed := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/conformance/first-value-wins"),
+		systemConfigFinder: nullConfigFinder,
+		HostResolver:       conformanceResolver,
+		IndexHosts:         true,
+	}
+	for _, key := range []string{"HostName", "Port", "User"} {
+		want, err := plain.GetStrict("foo", key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := indexed.GetStrict("foo", key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("GetStrict(foo, %q): got %q with index, want %q", key, got, want)
+		}
+	}
+	if indexed.userConfig.index == nil {
+		t.Error("expected the user config to be indexed")
+	}
+}
+
+func benchmarkGet(b *testing.B, index bool) {
+	cfg, err := DecodeBytes(generateConfig(4000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if index {
+		cfg.BuildIndex()
+	}
+	hosts := make([]string, 4000)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("web-%d", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		host := hosts[i%len(hosts)]
+		ctx := &MatchContext{Host: host, OriginalHost: host}
+		if _, err := cfg.Get("Port", ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B)        { benchmarkGet(b, false) }
+func BenchmarkGetIndexed(b *testing.B) { benchmarkGet(b, true) }
@@ -1,38 +1,42 @@
 package ssh_config
 
 import (
-	"bytes"
+	"unicode/utf8"
 )
 
 // Define state functions
-type sshLexStateFn func() sshLexStateFn
+type sshLexStateFn func(*sshLexer) sshLexStateFn
 
+// sshLexer splits a config file into tokens. It runs in the goroutine of the
+// parser: nextToken runs the state functions until they emit a token. Token
+// values are substrings of the input, so lexing allocates little.
 type sshLexer struct {
+	input    string // Textual source
 	inputIdx int
-	input    []rune // Textual source
-
-	buffer        []rune // Runes composing the current token
-	tokens        chan token
-	line          int
-	col           int
-	endbufferLine int
-	endbufferCol  int
+
+	state  sshLexStateFn
+	tokens []token // Emitted tokens, returned by nextToken from index head
+	head   int
+
+	// Offset, line and start of the line of the current token
+	start     int
+	line      int
+	lineStart int
+	// Line and start of the line at inputIdx
+	endbufferLine      int
+	endbufferLineStart int
 }
 
-func (s *sshLexer) lexComment(previousState sshLexStateFn) sshLexStateFn {
-	return func() sshLexStateFn {
-		growingString := ""
-		for next := s.peek(); next != '\n' && next != eof; next = s.peek() {
-			if next == '\r' && s.follow("\r\n") {
-				break
-			}
-			growingString += string(next)
-			s.next()
+func (s *sshLexer) lexComment() sshLexStateFn {
+	for next := s.peek(); next != '\n' && next != eof; next = s.peek() {
+		if next == '\r' && s.follow("\r\n") {
+			break
 		}
-		s.emitWithValue(tokenComment, growingString)
-		s.skip()
-		return previousState
+		s.next()
 	}
+	s.emit(tokenComment)
+	s.skip()
+	return (*sshLexer).lexVoid
 }
 
 // lex the space after an equals sign in a function
@@ -44,7 +48,7 @@ func (s *sshLexer) lexRspace() sshLexStateFn {
 		}
 		s.skip()
 	}
-	return s.lexRvalue
+	return (*sshLexer).lexRvalue
 }
 
 func (s *sshLexer) lexEquals() sshLexStateFn {
@@ -53,7 +57,7 @@ func (s *sshLexer) lexEquals() sshLexStateFn {
 		if next == '=' {
 			s.emit(tokenEquals)
 			s.skip()
-			return s.lexRspace
+			return (*sshLexer).lexRspace
 		}
 		// TODO error handling here; newline eof etc.
 		if !isSpace(next) {
@@ -61,86 +65,72 @@ func (s *sshLexer) lexEquals() sshLexStateFn {
 		}
 		s.skip()
 	}
-	return s.lexRvalue
+	return (*sshLexer).lexRvalue
 }
 
 func (s *sshLexer) lexKey() sshLexStateFn {
-	growingString := ""
-
 	for r := s.peek(); isKeyChar(r); r = s.peek() {
 		// simplified a lot here
 		if isSpace(r) || r == '=' {
-			s.emitWithValue(tokenKey, growingString)
+			s.emit(tokenKey)
 			s.skip()
-			return s.lexEquals
+			return (*sshLexer).lexEquals
 		}
-		growingString += string(r)
 		s.next()
 	}
-	s.emitWithValue(tokenKey, growingString)
-	return s.lexEquals
+	s.emit(tokenKey)
+	return (*sshLexer).lexEquals
 }
 
 func (s *sshLexer) lexRvalue() sshLexStateFn {
-	growingString := ""
 	for {
 		next := s.peek()
 		switch next {
 		case '\r':
 			if s.follow("\r\n") {
-				s.emitWithValue(tokenString, growingString)
+				s.emit(tokenString)
 				s.skip()
-				return s.lexVoid
+				return (*sshLexer).lexVoid
 			}
 		case '\n':
-			s.emitWithValue(tokenString, growingString)
+			s.emit(tokenString)
 			s.skip()
-			return s.lexVoid
+			return (*sshLexer).lexVoid
 		case '#':
-			s.emitWithValue(tokenString, growingString)
+			s.emit(tokenString)
 			s.skip()
-			return s.lexComment(s.lexVoid)
+			return (*sshLexer).lexComment
 		case eof:
 			s.next()
 		}
 		if next == eof {
 			break
 		}
-		growingString += string(next)
 		s.next()
 	}
 	s.emit(tokenEOF)
 	return nil
 }
 
-func (s *sshLexer) read() rune {
+// next advances past the next byte of the input, or past the end of the input
+// if there is none.
+func (s *sshLexer) next() rune {
 	r := s.peek()
 	if r == '\n' {
 		s.endbufferLine++
-		s.endbufferCol = 1
-	} else {
-		s.endbufferCol++
+		s.endbufferLineStart = s.inputIdx + 1
 	}
 	s.inputIdx++
 	return r
 }
 
-func (s *sshLexer) next() rune {
-	r := s.read()
-
-	if r != eof {
-		s.buffer = append(s.buffer, r)
-	}
-	return r
-}
-
 func (s *sshLexer) lexVoid() sshLexStateFn {
 	for {
 		next := s.peek()
 		switch next {
 		case '#':
 			s.skip()
-			return s.lexComment(s.lexVoid)
+			return (*sshLexer).lexComment
 		case '\r':
 			fallthrough
 		case '\n':
@@ -154,7 +144,7 @@ func (s *sshLexer) lexVoid() sshLexStateFn {
 		}
 
 		if isKeyStartChar(next) {
-			return s.lexKey
+			return (*sshLexer).lexKey
 		}
 
 		// removed IsKeyStartChar and lexKey. probably will need to readd
@@ -170,9 +160,9 @@ func (s *sshLexer) lexVoid() sshLexStateFn {
 }
 
 func (s *sshLexer) ignore() {
-	s.buffer = make([]rune, 0)
+	s.start = s.inputIdx
 	s.line = s.endbufferLine
-	s.col = s.endbufferCol
+	s.lineStart = s.endbufferLineStart
 }
 
 func (s *sshLexer) skip() {
@@ -180,17 +170,23 @@ func (s *sshLexer) skip() {
 	s.ignore()
 }
 
+// emit emits a token with the input consumed since the last call to ignore
+// as its value.
 func (s *sshLexer) emit(t tokenType) {
-	s.emitWithValue(t, string(s.buffer))
-}
-
-func (s *sshLexer) emitWithValue(t tokenType, value string) {
-	tok := token{
-		Position: Position{s.line, s.col},
-		typ:      t,
-		val:      value,
+	start, end := s.start, s.inputIdx
+	if end > len(s.input) {
+		end = len(s.input)
+	}
+	if start > end {
+		start = end
 	}
-	s.tokens <- tok
+	// Columns count runes, including those read past the end of the input.
+	col := utf8.RuneCountInString(s.input[s.lineStart:start]) + 1 + s.start - start
+	s.tokens = append(s.tokens, token{
+		Position: Position{s.line, col},
+		typ:      t,
+		val:      s.input[start:end],
+	})
 	s.ignore()
 }
 
@@ -198,43 +194,35 @@ func (s *sshLexer) peek() rune {
 	if s.inputIdx >= len(s.input) {
 		return eof
 	}
-
-	r := s.input[s.inputIdx]
-	return r
+	return rune(s.input[s.inputIdx])
 }
 
 func (s *sshLexer) follow(next string) bool {
-	inputIdx := s.inputIdx
-	for _, expectedRune := range next {
-		if inputIdx >= len(s.input) {
-			return false
-		}
-		r := s.input[inputIdx]
-		inputIdx++
-		if expectedRune != r {
-			return false
-		}
-	}
-	return true
+	return s.inputIdx < len(s.input) && len(s.input)-s.inputIdx >= len(next) &&
+		s.input[s.inputIdx:s.inputIdx+len(next)] == next
 }
 
-func (s *sshLexer) run() {
-	for state := s.lexVoid; state != nil; {
-		state = state()
+// nextToken returns the next token of the input, or false after the EOF
+// token has been returned.
+func (s *sshLexer) nextToken() (token, bool) {
+	for s.head == len(s.tokens) {
+		if s.state == nil {
+			return token{}, false
+		}
+		s.tokens, s.head = s.tokens[:0], 0
+		s.state = s.state(s)
 	}
-	close(s.tokens)
+	tok := s.tokens[s.head]
+	s.head++
+	return tok, true
 }
 
-func lexSSH(input []byte) chan token {
-	runes := bytes.Runes(input)
+func lexSSH(input string) *sshLexer {
 	l := &sshLexer{
-		input:         runes,
-		tokens:        make(chan token),
+		input:         input,
 		line:          1,
-		col:           1,
 		endbufferLine: 1,
-		endbufferCol:  1,
 	}
-	go l.run()
-	return l.tokens
+	l.state = (*sshLexer).lexVoid
+	return l
 }
@@ -0,0 +1,45 @@
+package ssh_config
+
+import (
+	"reflect"
+	"testing"
+)
+
+var lexTests = []struct {
+	in   string
+	want []token
+}{
+	{"Host a # c\n", []token{
+		{Position{1, 1}, tokenKey, "Host"},
+		{Position{1, 6}, tokenString, "a "},
+		{Position{1, 9}, tokenComment, " c"},
+		{Position{2, 1}, tokenEOF, ""},
+	}},
+	{"Port = 22\r\n", []token{
+		{Position{1, 1}, tokenKey, "Port"},
+		{Position{1, 6}, tokenEquals, ""},
+		{Position{1, 8}, tokenString, "22"},
+		{Position{1, 11}, tokenEmptyLine, ""},
+		{Position{2, 1}, tokenEOF, ""},
+	}},
+	// Columns count runes, and values are the bytes of the input.
+	{"User é\xff\n  Port 1", []token{
+		{Position{1, 1}, tokenKey, "User"},
+		{Position{1, 6}, tokenString, "é\xff"},
+		{Position{2, 3}, tokenKey, "Port"},
+		{Position{2, 8}, tokenEOF, "1"},
+	}},
+}
+
+func TestLex(t *testing.T) {
+	for _, tt := range lexTests {
+		var got []token
+		l := lexSSH(tt.in)
+		for tok, ok := l.nextToken(); ok; tok, ok = l.nextToken() {
+			got = append(got, tok)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lexSSH(%q):\ngot  %v\nwant %v", tt.in, got, tt.want)
+		}
+	}
+}
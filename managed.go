@@ -0,0 +1,329 @@
+package ssh_config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A managed region is a section of a config that is owned by a tool, and
+// delimited by two comment lines:
+//
+//	# BEGIN managed: vpn sha256:0123456789abcdef
+//	Host vpn1
+//	  HostName 10.0.0.1
+//	# END managed: vpn
+//
+// The checksum in the BEGIN line is that of the lines between the markers as
+// last written by SetManagedRegion, which tells whether they have been edited
+// since.
+const (
+	beginMarker = "BEGIN managed: "
+	endMarker   = "END managed: "
+	sumPrefix   = "sha256:"
+)
+
+// ManagedRegion describes a managed region of a config.
+type ManagedRegion struct {
+	// Name is the name in the markers of the region.
+	Name string
+	// Blocks are the Host and Match blocks in the region, which are also in
+	// the Blocks of the config.
+	Blocks []Block
+	// Edited is true if the lines of the region have been changed since they
+	// were written by SetManagedRegion. It is false if the BEGIN marker has
+	// no checksum.
+	Edited bool
+}
+
+// ErrRegionEdited is returned by SetManagedRegion if the region has been
+// edited since it was last written.
+var ErrRegionEdited = errors.New("ssh_config: managed region was edited by hand")
+
+// nodeRef is the index of a node in a block of a config.
+type nodeRef struct {
+	block, node int
+}
+
+// region is the location of a managed region in a config.
+type region struct {
+	begin, end nodeRef
+	sum        string // the checksum in the BEGIN marker, if any
+}
+
+// parseMarker parses the comment of a marker line into the kind of marker,
+// beginMarker or endMarker, the name of the region and, for a BEGIN marker,
+// the checksum.
+func parseMarker(comment string) (kind, name, sum string, ok bool) {
+	s := strings.TrimSpace(comment)
+	switch {
+	case strings.HasPrefix(s, beginMarker):
+		kind = beginMarker
+	case strings.HasPrefix(s, endMarker):
+		kind = endMarker
+	default:
+		return "", "", "", false
+	}
+	fields := strings.Fields(s[len(kind):])
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 2 && kind == beginMarker && strings.HasPrefix(fields[1], sumPrefix):
+		sum = fields[1][len(sumPrefix):]
+	default:
+		return "", "", "", false
+	}
+	return kind, fields[0], sum, true
+}
+
+// findRegion returns the location of the managed region with the given name
+// in c, or nil if there is none.
+func (c *Config) findRegion(name string) (*region, error) {
+	var r *region
+	found := false
+	for i, block := range c.Blocks {
+		for j, node := range block.GetNodes() {
+			e, ok := node.(*Empty)
+			if !ok {
+				continue
+			}
+			kind, n, sum, ok := parseMarker(e.Comment)
+			if !ok || n != name {
+				continue
+			}
+			switch {
+			case kind == beginMarker && r == nil && !found:
+				r = &region{begin: nodeRef{i, j}, sum: sum}
+			case kind == endMarker && r != nil && !found:
+				r.end = nodeRef{i, j}
+				found = true
+			default:
+				return nil, fmt.Errorf("ssh_config: unexpected %q at %s", strings.TrimSpace(e.Comment), e.Pos())
+			}
+		}
+	}
+	if r != nil && !found {
+		return nil, fmt.Errorf("ssh_config: managed region %q has no END marker", name)
+	}
+	return r, nil
+}
+
+// text returns the lines of c between the markers of r, as c prints them.
+func (c *Config) text(r *region) string {
+	var buf strings.Builder
+	first := c.Blocks[r.begin.block]
+	nodes := first.GetNodes()
+	if r.begin.block == r.end.block {
+		writeNodes(&buf, nodes[r.begin.node+1:r.end.node])
+		return buf.String()
+	}
+	writeNodes(&buf, nodes[r.begin.node+1:])
+	for _, block := range c.Blocks[r.begin.block+1 : r.end.block] {
+		buf.WriteString(block.String())
+	}
+	// Print the last block without the END marker and the lines after it.
+	last := c.Blocks[r.end.block]
+	nodes = last.GetNodes()
+	last.SetNodes(nodes[:r.end.node])
+	buf.WriteString(last.String())
+	last.SetNodes(nodes)
+	return buf.String()
+}
+
+func checksum(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ManagedRegion returns the managed region of c with the given name, or nil
+// if c has none. Only c itself is searched, not the files it includes. It
+// returns an error if the markers of the region are incomplete or appear more
+// than once.
+func (c *Config) ManagedRegion(name string) (*ManagedRegion, error) {
+	r, err := c.findRegion(name)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	return &ManagedRegion{
+		Name:   name,
+		Blocks: slices.Clone(c.Blocks[r.begin.block+1 : r.end.block+1]),
+		Edited: r.sum != "" && r.sum != checksum(c.text(r)),
+	}, nil
+}
+
+// RegionOptions control how SetManagedRegion changes a config.
+type RegionOptions struct {
+	// Index is the index in Blocks before which the region is created if
+	// the config has none, which must be at least 1, as the implicit block
+	// at the start of the config comes first. If Index is zero, the region
+	// is created at the end of the config.
+	Index int
+	// Force replaces the region even if it has been edited by hand.
+	Force bool
+}
+
+// SetManagedRegion replaces the blocks of the managed region of c with the
+// given name by blocks, and reports whether c was changed. The blocks are
+// added to c as they are, e.g. blocks of a config decoded from a snippet or
+// built with AddHost. If c has no region with that name, it is created
+// according to opts, along with its markers; comment lines directly above the
+// block it is inserted before stay with that block.
+//
+// If the region already contains the same lines, c is not changed, so calling
+// SetManagedRegion repeatedly with the same blocks is safe. If the region has
+// been edited by hand, SetManagedRegion returns ErrRegionEdited without
+// changing c, unless opts.Force is set.
+//
+// The blocks must not be in c already, except for the blocks of the region
+// itself, and must not include the implicit block at the start of a config,
+// which holds the lines before its first Host or Match block.
+func (c *Config) SetManagedRegion(name string, blocks []Block, opts RegionOptions) (bool, error) {
+	if name == "" || strings.ContainsAny(name, " \t\r\n#") {
+		return false, fmt.Errorf("ssh_config: invalid managed region name %q", name)
+	}
+	r, err := c.findRegion(name)
+	if err != nil {
+		return false, err
+	}
+	if r == nil && (opts.Index < 0 || opts.Index > len(c.Blocks) || opts.Index == 0 && len(c.Blocks) == 0) {
+		return false, fmt.Errorf("ssh_config: block index %d out of range [1, %d]", opts.Index, len(c.Blocks))
+	}
+	if err := c.checkBlocks(name, r, blocks); err != nil {
+		return false, err
+	}
+	st := c.editStyle()
+	var begin, end Node
+	var tail []Node
+	unchanged := false
+	if r != nil {
+		old := checksum(c.text(r))
+		if r.sum != "" && r.sum != old && !opts.Force {
+			return false, ErrRegionEdited
+		}
+		unchanged = r.sum == old
+		// Cut the region before printing blocks, which may be the blocks
+		// of the region.
+		begin, end, tail = c.cutRegion(r)
+	} else {
+		begin = &Empty{line: sourceLine{eol: st.eol}}
+		end = &Empty{Comment: " " + endMarker + name, line: sourceLine{eol: st.eol}}
+		r = &region{begin: nodeRef{block: c.insertMarker(opts.Index, begin, &tail)}}
+	}
+	var text strings.Builder
+	for _, block := range blocks {
+		// Terminate the last line of each block, as it is terminated once
+		// another line follows.
+		if nodes := block.GetNodes(); len(nodes) > 0 {
+			setTerminator(nodes[len(nodes)-1], st.eol)
+		} else if bd := blockData(block); bd != nil && bd.line.lineEnding() == "" {
+			bd.line.eol = st.eol
+		}
+		text.WriteString(block.String())
+	}
+	sum := checksum(text.String())
+	unchanged = unchanged && r.sum == sum
+	begin.(*Empty).Comment = " " + beginMarker + name + " " + sumPrefix + sum
+	i := r.begin.block
+	c.Blocks = slices.Insert(c.Blocks, i+1, blocks...)
+	owner := c.Blocks[i+len(blocks)]
+	nodes := owner.GetNodes()
+	if len(nodes) > 0 {
+		setTerminator(nodes[len(nodes)-1], st.eol)
+	}
+	owner.SetNodes(append(append(nodes, end), tail...))
+	c.reindex()
+	return !unchanged, nil
+}
+
+// checkBlocks returns an error if blocks cannot be added to the managed region
+// r of c: if one of them is an implicit block, is given twice, or is in c
+// outside of r.
+func (c *Config) checkBlocks(name string, r *region, blocks []Block) error {
+	var own []Block
+	if r != nil {
+		own = c.Blocks[r.begin.block+1 : r.end.block+1]
+	}
+	for i, b := range blocks {
+		if h, ok := b.(*Host); ok && h.implicit {
+			return errors.New("ssh_config: cannot add the implicit block at the start of a config to a managed region")
+		}
+		if containsBlock(blocks[:i], b) {
+			return errors.New("ssh_config: block given more than once for a managed region")
+		}
+		if containsBlock(c.Blocks, b) && !containsBlock(own, b) {
+			return fmt.Errorf("ssh_config: block is already in the config, outside of managed region %q", name)
+		}
+	}
+	return nil
+}
+
+func containsBlock(blocks []Block, b Block) bool {
+	for _, block := range blocks {
+		if block == b {
+			return true
+		}
+	}
+	return false
+}
+
+// cutRegion removes the lines of the region r from c, and returns its
+// markers and the lines after the END marker in its block.
+func (c *Config) cutRegion(r *region) (begin, end Node, tail []Node) {
+	first := c.Blocks[r.begin.block]
+	nodes := first.GetNodes()
+	begin = nodes[r.begin.node]
+	last := c.Blocks[r.end.block]
+	lastNodes := last.GetNodes()
+	end = lastNodes[r.end.node]
+	tail = slices.Clone(lastNodes[r.end.node+1:])
+	if r.end.block > r.begin.block {
+		last.SetNodes(lastNodes[:r.end.node])
+		c.Blocks = slices.Delete(c.Blocks, r.begin.block+1, r.end.block+1)
+	}
+	first.SetNodes(nodes[:r.begin.node+1])
+	return begin, end, tail
+}
+
+// insertMarker adds the BEGIN marker of a new region to be inserted before
+// c.Blocks[index], or at the end of c if index is zero. It returns the index
+// of the block the marker was added to, and sets tail to the lines to add
+// after the END marker.
+func (c *Config) insertMarker(index int, begin Node, tail *[]Node) int {
+	st := c.editStyle()
+	if index == 0 {
+		index = len(c.Blocks)
+	}
+	prev := c.Blocks[index-1]
+	nodes := prev.GetNodes()
+	// Comment lines directly above the next block stay with it.
+	j := len(nodes)
+	if index < len(c.Blocks) {
+		j = attachedComments(nodes)
+	}
+	comments := slices.Clone(nodes[j:])
+	nodes = nodes[:j]
+	if len(nodes) > 0 && !isBlank(nodes[len(nodes)-1]) || index > 1 && len(nodes) == 0 {
+		if len(nodes) > 0 {
+			setTerminator(nodes[len(nodes)-1], st.eol)
+		}
+		nodes = append(nodes, st.blank())
+	}
+	prev.SetNodes(append(nodes, begin))
+	if index < len(c.Blocks) {
+		*tail = append([]Node{st.blank()}, comments...)
+	}
+	return index - 1
+}
+
+// blockData returns the BlockData of a Host or Match block.
+func blockData(b Block) *BlockData {
+	switch t := b.(type) {
+	case *Host:
+		return t.BlockData
+	case *Match:
+		return t.BlockData
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package ssh_config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func decodeBlocks(t *testing.T, s string) []Block {
+	t.Helper()
+	cfg, err := DecodeBytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Blocks[1:]
+}
+
+var managedTests = []struct {
+	name  string
+	in    string
+	index int
+	want  string
+}{
+	{
+		"create at end",
+		"Host a\n  User a\n",
+		0,
+		"Host a\n  User a\n\n# BEGIN managed: vpn sha256:%s\nHost vpn\n  HostName 10.0.0.1\n# END managed: vpn\n",
+	},
+	{
+		"create at start",
+		"# my hosts\nCompression yes\n\n# about a\nHost a\n  User a\n",
+		1,
+		"# my hosts\nCompression yes\n\n# BEGIN managed: vpn sha256:%s\nHost vpn\n  HostName 10.0.0.1\n# END managed: vpn\n\n# about a\nHost a\n  User a\n",
+	},
+	{
+		"create in empty file",
+		"",
+		1,
+		"# BEGIN managed: vpn sha256:%s\nHost vpn\n  HostName 10.0.0.1\n# END managed: vpn\n",
+	},
+	{
+		"replace",
+		"Host a\n  User a\n\n# BEGIN managed: vpn\nHost old1\n  HostName 1\n\nHost old2\n  HostName 2\n# END managed: vpn\n\nHost b\n  User b\n",
+		0,
+		"Host a\n  User a\n\n# BEGIN managed: vpn sha256:%s\nHost vpn\n  HostName 10.0.0.1\n# END managed: vpn\n\nHost b\n  User b\n",
+	},
+	{
+		"replace empty region",
+		"\tCompression yes\n# BEGIN managed: vpn\n  # END managed: vpn\n\nHost b\n  User b\n",
+		0,
+		"\tCompression yes\n# BEGIN managed: vpn sha256:%s\nHost vpn\n  HostName 10.0.0.1\n  # END managed: vpn\n\nHost b\n  User b\n",
+	},
+}
+
+func TestSetManagedRegion(t *testing.T) {
+	const snippet = "Host vpn\n  HostName 10.0.0.1\n"
+	sum := checksum(snippet)
+	for _, tt := range managedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := DecodeBytes([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed, err := cfg.SetManagedRegion("vpn", decodeBlocks(t, snippet), RegionOptions{Index: tt.index})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Error("expected a change")
+			}
+			want := strings.Replace(tt.want, "%s", sum, 1)
+			got := cfg.String()
+			if got != want {
+				t.Fatalf("got  %q\nwant %q", got, want)
+			}
+
+			// Setting the same blocks again, also after reading the
+			// result back, changes nothing.
+			changed, err = cfg.SetManagedRegion("vpn", decodeBlocks(t, snippet), RegionOptions{})
+			if err != nil || changed {
+				t.Errorf("second SetManagedRegion: got %v, %v", changed, err)
+			}
+			again, err := DecodeBytes([]byte(got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := again.ManagedRegion("vpn")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r == nil || r.Edited || len(r.Blocks) != 1 {
+				t.Fatalf("ManagedRegion: got %+v", r)
+			}
+			changed, err = again.SetManagedRegion("vpn", r.Blocks, RegionOptions{})
+			if err != nil || changed {
+				t.Errorf("SetManagedRegion with its own blocks: got %v, %v", changed, err)
+			}
+			if s := again.String(); s != want {
+				t.Errorf("read back as %q", s)
+			}
+		})
+	}
+}
+
+func TestManagedRegionEdited(t *testing.T) {
+	cfg, err := DecodeBytes([]byte("Host a\n  User a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.SetManagedRegion("vpn", decodeBlocks(t, "Host vpn\n  User x\n"), RegionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(cfg.String(), "User x", "User y", 1)
+	cfg, err = DecodeBytes([]byte(edited))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := cfg.ManagedRegion("vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || !r.Edited {
+		t.Fatalf("ManagedRegion: got %+v, want an edited region", r)
+	}
+	blocks := decodeBlocks(t, "Host vpn\n  User z\n")
+	if _, err := cfg.SetManagedRegion("vpn", blocks, RegionOptions{}); !errors.Is(err, ErrRegionEdited) {
+		t.Fatalf("SetManagedRegion: got %v, want %v", err, ErrRegionEdited)
+	}
+	if cfg.String() != edited {
+		t.Fatal("config changed by failed SetManagedRegion")
+	}
+	if _, err := cfg.SetManagedRegion("vpn", blocks, RegionOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg.String(), "User z\n# END managed: vpn\n") {
+		t.Errorf("forced SetManagedRegion: got %q", cfg.String())
+	}
+}
+
+func TestManagedRegionErrors(t *testing.T) {
+	for _, in := range []string{
+		"# BEGIN managed: vpn\nHost a\n",
+		"# END managed: vpn\n# BEGIN managed: vpn\n",
+		"# BEGIN managed: vpn\n# END managed: vpn\n# BEGIN managed: vpn\n# END managed: vpn\n",
+	} {
+		cfg, err := DecodeBytes([]byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cfg.ManagedRegion("vpn"); err == nil {
+			t.Errorf("ManagedRegion(%q): expected an error", in)
+		}
+		if _, err := cfg.SetManagedRegion("vpn", nil, RegionOptions{}); err == nil {
+			t.Errorf("SetManagedRegion(%q): expected an error", in)
+		}
+	}
+	cfg, err := DecodeBytes([]byte("# BEGIN managed: other\n# END managed: other\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := cfg.ManagedRegion("vpn"); r != nil || err != nil {
+		t.Errorf("ManagedRegion(vpn): got %v, %v", r, err)
+	}
+	if _, err := cfg.SetManagedRegion("my vpn", nil, RegionOptions{}); err == nil {
+		t.Error("SetManagedRegion with a space in the name: expected an error")
+	}
+	if _, err := cfg.SetManagedRegion("vpn", nil, RegionOptions{Index: 2}); err == nil {
+		t.Error("SetManagedRegion with an index out of range: expected an error")
+	}
+}
+
+func TestSetManagedRegionBlocks(t *testing.T) {
+	const in = "Host a\n  User a\n"
+	snippet, err := DecodeBytes([]byte("Compression yes\n\nHost vpn\n  HostName 10.0.0.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := DecodeBytes([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		blocks []Block
+	}{
+		{"implicit block", snippet.Blocks},
+		{"block in config", cfg.Blocks[1:]},
+		{"block given twice", []Block{snippet.Blocks[1], snippet.Blocks[1]}},
+	}
+	for _, tt := range tests {
+		if _, err := cfg.SetManagedRegion("vpn", tt.blocks, RegionOptions{}); err == nil {
+			t.Errorf("SetManagedRegion with %s: expected an error", tt.name)
+		}
+		if got := cfg.String(); got != in {
+			t.Errorf("SetManagedRegion with %s changed the config to %q", tt.name, got)
+		}
+	}
+}
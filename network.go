@@ -0,0 +1,96 @@
+package ssh_config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// InterfaceLister lists the addresses of the local network interfaces that
+// "Match localnetwork" criteria are evaluated against.
+type InterfaceLister interface {
+	// InterfaceAddrs returns the addresses of all local interfaces that are
+	// up.
+	InterfaceAddrs() ([]net.Addr, error)
+}
+
+// systemInterfaces is the default InterfaceLister, which reports the
+// addresses of the interfaces of this machine.
+type systemInterfaces struct{}
+
+func (systemInterfaces) InterfaceAddrs() ([]net.Addr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []net.Addr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, ifaddrs...)
+	}
+	return addrs, nil
+}
+
+// parseCIDRList parses a comma-separated list of networks in CIDR notation,
+// e.g. "10.0.0.0/8,192.168.1.0/24". An address without a prefix length is
+// treated as a single host.
+func parseCIDRList(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// matchLocalNetwork reports whether any local interface address lies within
+// one of nets. Interface addresses are listed once per ctx.
+func (ctx *MatchContext) matchLocalNetwork(nets []*net.IPNet) bool {
+	if ctx.localAddrs == nil {
+		lister := ctx.Interfaces
+		if lister == nil {
+			lister = systemInterfaces{}
+		}
+		addrs, err := lister.InterfaceAddrs()
+		if err != nil {
+			return false
+		}
+		ctx.localAddrs = make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			switch a := addr.(type) {
+			case *net.IPNet:
+				ctx.localAddrs = append(ctx.localAddrs, a.IP)
+			case *net.IPAddr:
+				ctx.localAddrs = append(ctx.localAddrs, a.IP)
+			}
+		}
+	}
+	for _, ip := range ctx.localAddrs {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package ssh_config
+
+import (
+	"net"
+	"testing"
+)
+
+type stubInterfaces []string
+
+func (s stubInterfaces) InterfaceAddrs() ([]net.Addr, error) {
+	addrs := make([]net.Addr, len(s))
+	for i := range s {
+		ip, n, err := net.ParseCIDR(s[i])
+		if err != nil {
+			return nil, err
+		}
+		addrs[i] = &net.IPNet{IP: ip, Mask: n.Mask}
+	}
+	return addrs, nil
+}
+
+var localNetworkTests = []struct {
+	addrs []string
+	want  string
+}{
+	{[]string{"127.0.0.1/8", "10.1.2.3/16"}, "none"},
+	{[]string{"127.0.0.1/8", "192.168.1.20/24"}, "none"},
+	{[]string{"127.0.0.1/8", "192.168.2.20/24"}, "bastion"},
+	{[]string{"::1/128", "2001:db8::1/64"}, "bastion6"},
+	{nil, "bastion"},
+}
+
+func TestMatchLocalNetwork(t *testing.T) {
+	for _, tt := range localNetworkTests {
+		us := &UserSettings{
+			userConfigFinder:   testConfigFinder("testdata/match-localnetwork"),
+			systemConfigFinder: nullConfigFinder,
+			Interfaces:         stubInterfaces(tt.addrs),
+		}
+		val, err := us.GetStrict("example.com", "ProxyJump", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != tt.want {
+			t.Errorf("addrs %v: expected ProxyJump %q, got %q", tt.addrs, tt.want, val)
+		}
+	}
+}
+
+func TestParseCIDRList(t *testing.T) {
+	nets, err := parseCIDRList("10.0.0.0/8,192.168.1.7,2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.7/32", "2001:db8::/32"}
+	if len(nets) != len(want) {
+		t.Fatalf("expected %d networks, got %v", len(want), nets)
+	}
+	for i := range want {
+		if nets[i].String() != want[i] {
+			t.Errorf("network %d: got %v, want %v", i, nets[i], want[i])
+		}
+	}
+
+	for _, in := range []string{"10.0.0.0/33", "example.com", "10.0.0.0/8,"} {
+		if _, err := parseCIDRList(in); err == nil {
+			t.Errorf("parseCIDRList(%q): expected error, got nil", in)
+		}
+	}
+	if _, err := DecodeBytes([]byte("Match localnetwork 10.0.0.0/33\n")); err == nil {
+		t.Error("expected error decoding invalid localnetwork list")
+	}
+}
@@ -1,41 +1,117 @@
 package ssh_config
 
 import (
+	"errors"
 	"fmt"
 	"slices"
 	"strings"
 	"unicode"
 )
 
-// TODO extend this by at least "localnetwork"
-var allowedMatchKeywords = []string{"host", "originalhost", "user", "localuser"}
+var allowedMatchKeywords = []string{"host", "originalhost", "user", "localuser", "exec", "localnetwork", "tagged", "version", "sessiontype", "command"}
 
 type sshParser struct {
-	flow          chan token
+	lexer         *sshLexer
 	config        *Config
 	tokensBuffer  []token
 	currentTable  []string
 	seenTableKeys []string
-	// /etc/ssh parser or local parser - used to find the default for relative
-	// filepaths in the Include directive
-	system bool
-	depth  uint8
+	// decoder of the file and the files it includes
+	d *decoder
+	// name and lines of the file, for error messages and to print unchanged
+	// lines as they were read
+	name  string
+	lines []sourceLine
+	// the last line a node was added for
+	lastLine int
+	depth    uint8
+	// the error that stopped the parser, if any
+	err error
 }
 
 type sshParserStateFn func() sshParserStateFn
 
-// Formats and panics an error message based on a token
-func (p *sshParser) raiseErrorf(tok *token, msg string) {
-	// TODO this format is ugly
-	panic(tok.Position.String() + ": " + msg)
+// raiseErrorf records an error at the position of tok with the given cause.
+// In tolerant mode the error is added to the diagnostics of the decoder and
+// parsing continues; otherwise it stops the parser, and the caller must
+// return the state returned by skipLine.
+func (p *sshParser) raiseErrorf(tok *token, cause error, format string, args ...interface{}) {
+	p.raise(&ParseError{
+		Filename:     p.name,
+		Position:     tok.Position,
+		Line:         p.line(tok.Position.Line).text,
+		IncludeChain: append([]IncludeStep(nil), p.d.chain...),
+		Msg:          fmt.Sprintf(format, args...),
+		Err:          cause,
+	})
 }
 
-func (p *sshParser) raiseError(tok *token, err error) {
+func (p *sshParser) raise(err *ParseError) {
+	if p.d.tolerant {
+		p.d.diagnostics = append(p.d.diagnostics, err)
+		return
+	}
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// skipLine returns the state to continue with after an error in the current
+// line: the next line in tolerant mode, or nil to stop the parser.
+func (p *sshParser) skipLine() sshParserStateFn {
+	if p.d.tolerant {
+		return p.parseStart
+	}
+	return nil
+}
+
+// raiseIncludeError records err, returned for the Include directive at tok.
+// Errors in included files already carry their position in that file.
+func (p *sshParser) raiseIncludeError(tok *token, err error) {
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		p.raise(perr)
+		return
+	}
 	if err == ErrDepthExceeded {
-		panic(err)
+		p.raiseErrorf(tok, err, "%v", err)
+		return
+	}
+	p.raiseErrorf(tok, fmt.Errorf("%w: %v", ErrInclude, err), "Error parsing Include directive: %v", err)
+}
+
+// line returns the 1-indexed line n of the file.
+func (p *sshParser) line(n int) sourceLine {
+	if n < 1 || n > len(p.lines) {
+		return sourceLine{}
+	}
+	return p.lines[n-1]
+}
+
+// source returns line n of the file, for a node with the given fields.
+func (p *sshParser) source(n int, fields string) sourceLine {
+	l := p.line(n)
+	l.fields, l.read = fields, true
+	return l
+}
+
+// fill adds Empty nodes to the last block for the lines before line that no
+// node was added for, such as lines skipped after an error or a last line
+// with only whitespace, so that the Config prints every line of the file.
+func (p *sshParser) fill(line int) {
+	for n := p.lastLine + 1; n < line && n <= len(p.lines); n++ {
+		e := &Empty{indent: indentOf(p.lines[n-1].text), position: Position{n, 1}}
+		e.line = p.source(n, e.fields())
+		p.addNode(e)
 	}
-	// TODO this format is ugly
-	panic(tok.Position.String() + ": " + err.Error())
+	if line > p.lastLine {
+		p.lastLine = line
+	}
+}
+
+func (p *sshParser) addNode(n Node) {
+	lastBlock := p.config.Blocks[len(p.config.Blocks)-1]
+	lastBlock.SetNodes(append(lastBlock.GetNodes(), n))
 }
 
 func (p *sshParser) run() {
@@ -49,7 +125,7 @@ func (p *sshParser) peek() *token {
 		return &(p.tokensBuffer[0])
 	}
 
-	tok, ok := <-p.flow
+	tok, ok := p.lexer.nextToken()
 	if !ok {
 		return nil
 	}
@@ -63,7 +139,7 @@ func (p *sshParser) getToken() *token {
 		p.tokensBuffer = p.tokensBuffer[1:]
 		return &tok
 	}
-	tok, ok := <-p.flow
+	tok, ok := p.lexer.nextToken()
 	if !ok {
 		return nil
 	}
@@ -86,19 +162,24 @@ func (p *sshParser) parseStart() sshParserStateFn {
 	case tokenEOF:
 		return nil
 	default:
-		p.raiseErrorf(tok, fmt.Sprintf("unexpected token %q\n", tok))
+		p.getToken()
+		p.raiseErrorf(tok, ErrSyntax, "unexpected token %q", tok)
+		return p.skipLine()
 	}
-	return nil
 }
 
 func (p *sshParser) parseKV() sshParserStateFn {
 	key := p.getToken()
 	hasEquals := false
 	val := p.getToken()
-	if val.typ == tokenEquals {
+	if val != nil && val.typ == tokenEquals {
 		hasEquals = true
 		val = p.getToken()
 	}
+	if val == nil {
+		p.raiseErrorf(key, ErrSyntax, "no value found for %q", key.val)
+		return p.skipLine()
+	}
 	comment := ""
 	tok := p.peek()
 	if tok == nil {
@@ -114,147 +195,312 @@ func (p *sshParser) parseKV() sshParserStateFn {
 		spaceBeforeComment := val.val[len(hostval):]
 		val.val = hostval
 
-		strPatterns := strings.Split(strings.ToLower(val.val), " ")
-		patterns := make(map[string]*Pattern, len(strPatterns)/2)
-		final := false
-
-	loop:
-		for i := 0; i < len(strPatterns); i++ {
-			k := strPatterns[i]
-
-			switch k {
-			case "canonical":
-				p.raiseErrorf(val, fmt.Sprintf("'canonical' keyword not supported"))
-				return nil
-			case "final":
-				final = true
-				continue
-			case "all":
-				if !(i == 1 && strPatterns[i-1] == "final" && len(strPatterns) == 2) && !(i == 0 && len(strPatterns) == 1) {
-					p.raiseErrorf(val, fmt.Sprintf("'all' keyword must be alone or immediately after 'final'"))
-					return nil
-				}
-				break loop // no patterns ^= always matches
-			}
-
-			if !slices.Contains(allowedMatchKeywords, k) {
-				p.raiseErrorf(val, fmt.Sprintf("Match keyword not supported: %v", k))
-				return nil
-			}
-
-			i++
-			if i >= len(strPatterns) {
-				p.raiseErrorf(val, fmt.Sprintf("No value found after Match keyword %q", k))
-				return nil
-			}
-			pat, err := NewPattern(strPatterns[i])
-			if err != nil {
-				p.raiseErrorf(val, fmt.Sprintf("Invalid Match pattern: %v", err))
-				return nil
-			}
-			patterns[k] = pat
+		criteria, final, ok := p.parseMatchCriteria(val)
+		if !ok && !p.d.tolerant {
+			return nil
 		}
-
-		p.config.Blocks = append(p.config.Blocks, &Match{
-			Patterns: patterns,
+		m := &Match{
+			Criteria: criteria,
 			BlockData: &BlockData{
 				Nodes:              make([]Node, 0),
 				EOLComment:         comment,
 				spaceBeforeComment: spaceBeforeComment,
 				hasEquals:          hasEquals,
+				keyword:            key.val,
+				indent:             indentOf(p.line(key.Position.Line).text),
 				Final:              final,
+				invalid:            !ok,
+				value:              val.val,
+				style:              p.config.style,
 			},
-		})
+		}
+		m.line = p.source(key.Position.Line, m.fields())
+		p.fill(key.Position.Line)
+		p.config.Blocks = append(p.config.Blocks, m)
 		return p.parseStart
 	}
 	if strings.ToLower(key.val) == "host" {
-		strPatterns := strings.Split(val.val, " ")
-		patterns := make([]*Pattern, 0)
-		for i := range strPatterns {
-			if strPatterns[i] == "" {
-				continue
-			}
-			pat, err := NewPattern(strPatterns[i])
-			if err != nil {
-				p.raiseErrorf(val, fmt.Sprintf("Invalid host pattern: %v", err))
-				return nil
-			}
-			patterns = append(patterns, pat)
+		patterns, ok := p.parseHostPatterns(val)
+		if !ok && !p.d.tolerant {
+			return nil
 		}
 		// val.val at this point could be e.g. "example.com       "
 		hostval := strings.TrimRightFunc(val.val, unicode.IsSpace)
 		spaceBeforeComment := val.val[len(hostval):]
 		val.val = hostval
-		p.config.Blocks = append(p.config.Blocks, &Host{
+		h := &Host{
 			Patterns: patterns,
 			BlockData: &BlockData{
 				Nodes:              make([]Node, 0),
 				EOLComment:         comment,
 				spaceBeforeComment: spaceBeforeComment,
 				hasEquals:          hasEquals,
+				keyword:            key.val,
+				indent:             indentOf(p.line(key.Position.Line).text),
+				invalid:            !ok,
+				value:              val.val,
+				style:              p.config.style,
 			},
-		})
+		}
+		h.line = p.source(key.Position.Line, h.fields())
+		p.fill(key.Position.Line)
+		p.config.Blocks = append(p.config.Blocks, h)
 		return p.parseStart
 	}
-	lastBlock := p.config.Blocks[len(p.config.Blocks)-1]
+	shortval := strings.TrimRightFunc(val.val, unicode.IsSpace)
+	spaceAfterValue := val.val[len(shortval):]
 	if strings.ToLower(key.val) == "include" {
-		inc, err := NewInclude(strings.Split(val.val, " "), hasEquals, key.Position, comment, p.system, p.depth+1)
-		if err == ErrDepthExceeded {
-			p.raiseError(val, err)
-			return nil
-		}
+		p.d.chain = append(p.d.chain, IncludeStep{Filename: p.name, Position: key.Position})
+		inc, err := newInclude(strings.Split(shortval, " "), hasEquals, key.Position, comment, p.d, p.depth+1)
+		p.d.chain = p.d.chain[:len(p.d.chain)-1]
 		if err != nil {
-			p.raiseErrorf(val, fmt.Sprintf("Error parsing Include directive: %v", err))
-			return nil
+			p.raiseIncludeError(key, err)
+			return p.skipLine()
 		}
-		lastBlock.SetNodes(append(lastBlock.GetNodes(), inc))
+		inc.indent = indentOf(p.line(key.Position.Line).text)
+		inc.spaceAfterValue = spaceAfterValue
+		inc.line = p.source(key.Position.Line, inc.fields())
+		p.fill(key.Position.Line)
+		p.addNode(inc)
 		return p.parseStart
 	}
-	shortval := strings.TrimRightFunc(val.val, unicode.IsSpace)
-	spaceAfterValue := val.val[len(shortval):]
+	if p.d.strict && !p.checkStrict(key, shortval) {
+		return p.skipLine()
+	}
 	kv := &KV{
 		Key:             key.val,
 		Value:           shortval,
 		spaceAfterValue: spaceAfterValue,
 		Comment:         comment,
 		hasEquals:       hasEquals,
-		leadingSpace:    key.Position.Col - 1,
+		indent:          indentOf(p.line(key.Position.Line).text),
 		position:        key.Position,
 	}
-	lastBlock.SetNodes(append(lastBlock.GetNodes(), kv))
+	kv.line = p.source(key.Position.Line, kv.fields())
+	p.fill(key.Position.Line)
+	p.addNode(kv)
 	return p.parseStart
 }
 
+// parseMatchCriteria parses the criteria of the Match directive with the
+// value tok. If they are invalid, it raises an error and returns false.
+func (p *sshParser) parseMatchCriteria(tok *token) ([]*MatchCriterion, bool, bool) {
+	val := tok
+	args, raw, err := splitRawArgs(val.val)
+	if err != nil {
+		p.raiseErrorf(val, fmt.Errorf("%w: %v", ErrSyntax, err), "Invalid Match arguments: %v", err)
+		return nil, false, false
+	}
+	criteria := make([]*MatchCriterion, 0, len(args)/2)
+	final := false
+
+loop:
+	for i := 0; i < len(args); i++ {
+		negated := strings.HasPrefix(args[i], "!")
+		spelling := strings.TrimPrefix(args[i], "!")
+		k := strings.ToLower(spelling)
+
+		switch k {
+		case "canonical":
+			criteria = append(criteria, &MatchCriterion{Keyword: k, Negated: negated, spelling: spelling})
+			continue
+		case "final":
+			// As in OpenSSH, only "final" without negation requests a
+			// final pass; "!all" never matches.
+			final = final || !negated
+			criteria = append(criteria, &MatchCriterion{Keyword: k, Negated: negated, spelling: spelling})
+			continue
+		case "all":
+			if !(i == 1 && len(args) == 2 && len(criteria) == 1) && !(i == 0 && len(args) == 1) {
+				p.raiseErrorf(val, ErrInvalidMatch, "'all' keyword must be alone or immediately after 'final' or 'canonical'")
+				return nil, false, false
+			}
+			criteria = append(criteria, &MatchCriterion{Keyword: k, Negated: negated, spelling: spelling})
+			break loop
+		}
+
+		if !slices.Contains(allowedMatchKeywords, k) {
+			p.raiseErrorf(val, ErrInvalidMatch, "Match keyword not supported: %v", k)
+			return nil, false, false
+		}
+
+		i++
+		if i >= len(args) {
+			p.raiseErrorf(val, ErrInvalidMatch, "No value found after Match keyword %q", k)
+			return nil, false, false
+		}
+		criterion := &MatchCriterion{Keyword: k, Negated: negated, Arg: args[i], spelling: spelling, rawArg: raw[i]}
+		switch k {
+		case "exec":
+		case "localnetwork":
+			nets, err := parseCIDRList(args[i])
+			if err != nil {
+				p.raiseErrorf(val, fmt.Errorf("%w: %v", ErrInvalidMatch, err), "Invalid Match localnetwork list: %v", err)
+				return nil, false, false
+			}
+			criterion.Networks = nets
+		case "command":
+			// Commands commonly contain commas, so the argument is a
+			// single pattern rather than a list.
+			pat, err := newPattern(args[i], false)
+			if err != nil {
+				p.raiseErrorf(val, fmt.Errorf("%w: %v", ErrInvalidPattern, err), "Invalid Match pattern: %v", err)
+				return nil, false, false
+			}
+			criterion.Patterns = &PatternList{str: args[i], patterns: []*Pattern{pat}}
+		default:
+			// Host names are matched case-insensitively, everything
+			// else is case sensitive.
+			fold := k == "host" || k == "originalhost"
+			list, err := newPatternList(args[i], fold)
+			if err != nil {
+				p.raiseErrorf(val, fmt.Errorf("%w: %v", ErrInvalidPattern, err), "Invalid Match pattern: %v", err)
+				return nil, false, false
+			}
+			criterion.Patterns = list
+		}
+		criteria = append(criteria, criterion)
+	}
+	return criteria, final, true
+}
+
+// parseHostPatterns parses the patterns of the Host directive with the value
+// tok. If they are invalid, it raises an error and returns false.
+func (p *sshParser) parseHostPatterns(tok *token) ([]*Pattern, bool) {
+	strPatterns, err := splitArgs(tok.val)
+	if err != nil {
+		p.raiseErrorf(tok, fmt.Errorf("%w: %v", ErrInvalidPattern, err), "Invalid host pattern: %v", err)
+		return nil, false
+	}
+	patterns := make([]*Pattern, 0)
+	for i := range strPatterns {
+		pat, err := NewPattern(strPatterns[i])
+		if err != nil {
+			p.raiseErrorf(tok, fmt.Errorf("%w: %v", ErrInvalidPattern, err), "Invalid host pattern: %v", err)
+			return nil, false
+		}
+		patterns = append(patterns, pat)
+	}
+	return patterns, true
+}
+
+// checkStrict raises an error and returns false if key is not a known keyword
+// and does not match an IgnoreUnknown pattern, or if value is not valid for
+// key.
+func (p *sshParser) checkStrict(key *token, value string) bool {
+	lkey := strings.ToLower(key.val)
+	if lkey == "ignoreunknown" {
+		list, err := NewPatternList(value)
+		if err != nil {
+			p.raiseErrorf(key, fmt.Errorf("%w: %v", ErrInvalidPattern, err), "Invalid IgnoreUnknown pattern: %v", err)
+			return false
+		}
+		p.d.ignoreUnknown = append(p.d.ignoreUnknown, list)
+	}
+	if knownKeywords[lkey] == "" {
+		if p.d.ignored(lkey) {
+			return true
+		}
+		p.raiseErrorf(key, ErrUnknownKeyword, "Bad configuration option: %s", key.val)
+		return false
+	}
+	if err := validate(key.val, value); err != nil {
+		p.raiseErrorf(key, fmt.Errorf("%w: %v", ErrInvalidValue, err), "%v", err)
+		return false
+	}
+	return true
+}
+
 func (p *sshParser) parseComment() sshParserStateFn {
 	comment := p.getToken()
-	lastHost := p.config.Blocks[len(p.config.Blocks)-1]
-	lastHost.SetNodes(append(lastHost.GetNodes(), &Empty{
-		Comment: comment.val,
-		// account for the "#" as well
-		leadingSpace: comment.Position.Col - 2,
-		position:     comment.Position,
-	}))
+	if comment.typ == tokenEmptyLine || comment.Position.Line <= p.lastLine {
+		// fill adds lines without a node, and the lexer emits empty
+		// lines for each "\r", including those of "\r\n" terminators.
+		return p.parseStart
+	}
+	e := &Empty{
+		Comment:  comment.val,
+		indent:   indentOf(p.line(comment.Position.Line).text),
+		position: comment.Position,
+	}
+	e.line = p.source(comment.Position.Line, e.fields())
+	p.fill(comment.Position.Line)
+	p.addNode(e)
 	return p.parseStart
 }
 
-func parseSSH(flow chan token, system bool, depth uint8) *Config {
-	// Ensure we consume tokens to completion even if parser exits early
-	defer func() {
-		for range flow {
+// splitArgs splits s into whitespace-separated arguments. As in OpenSSH,
+// arguments may be enclosed in double or single quotes to include whitespace,
+// and a backslash escapes a following quote or backslash.
+func splitArgs(s string) ([]string, error) {
+	args, _, err := splitRawArgs(s)
+	return args, err
+}
+
+// splitRawArgs is like splitArgs, but also returns the arguments as they
+// appear in s, including quotes and backslashes.
+func splitRawArgs(s string) ([]string, []string, error) {
+	var args, raw []string
+	var buf strings.Builder
+	var quote byte
+	inArg := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !inArg {
+			start = i
+		}
+		switch {
+		case quote == 0 && isSpace(rune(c)):
+			if inArg {
+				args = append(args, buf.String())
+				raw = append(raw, s[start:i])
+				buf.Reset()
+				inArg = false
+			}
+			continue
+		case c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\'' || s[i+1] == '\\' || (quote == 0 && s[i+1] == ' ')):
+			i++
+			buf.WriteByte(s[i])
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote != 0 && c == quote:
+			quote = 0
+		default:
+			buf.WriteByte(c)
 		}
-	}()
+		inArg = true
+	}
+	if quote != 0 {
+		return nil, nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, buf.String())
+		raw = append(raw, s[start:])
+	}
+	return args, raw, nil
+}
 
+func parseSSH(src []byte, d *decoder, name string, depth uint8) (*Config, error) {
+	text := string(src)
 	result := newConfig()
 	result.position = Position{1, 1}
 	parser := &sshParser{
-		flow:          flow,
+		lexer:         lexSSH(text),
 		config:        result,
 		tokensBuffer:  make([]token, 0),
 		currentTable:  make([]string, 0),
 		seenTableKeys: make([]string, 0),
-		system:        system,
+		d:             d,
+		name:          name,
+		lines:         splitLines(text),
 		depth:         depth,
 	}
 	parser.run()
-	return result
+	if parser.err != nil {
+		return nil, parser.err
+	}
+	parser.fill(len(parser.lines) + 1)
+	result.inferStyle(parser.lines)
+	return result, nil
 }
@@ -1,7 +1,10 @@
 package ssh_config
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -22,3 +25,87 @@ func TestIOError(t *testing.T) {
 		t.Errorf("expected read error msg, got %v", err)
 	}
 }
+
+var splitArgsTests = []struct {
+	in   string
+	want []string
+	err  bool
+}{
+	{"host a", []string{"host", "a"}, false},
+	{"  host\t a  ", []string{"host", "a"}, false},
+	{`exec "test -f ~/.vpn-up"`, []string{"exec", "test -f ~/.vpn-up"}, false},
+	{`exec 'a "b"'`, []string{"exec", `a "b"`}, false},
+	{`exec a\ b\"`, []string{"exec", `a b"`}, false},
+	{`exec ""`, []string{"exec", ""}, false},
+	{`exec "unterminated`, nil, true},
+}
+
+func TestSplitArgs(t *testing.T) {
+	for _, tt := range splitArgsTests {
+		got, err := splitArgs(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("splitArgs(%q): got err %v, want err %v", tt.in, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q): got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// generateConfig returns a config file with n Host blocks, similar to those
+// generated by inventory tools.
+func generateConfig(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# generated, do not edit\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "Host web-%d web-%d.example.com # rack %d\n", i, i, i%40)
+		fmt.Fprintf(&buf, "    HostName 10.%d.%d.%d\n", i/65536, i/256%256, i%256)
+		buf.WriteString("    User deploy\n")
+		fmt.Fprintf(&buf, "    Port = %d\n", 2200+i%100)
+		buf.WriteString("    IdentityFile ~/.ssh/id_ed25519\n")
+		buf.WriteString("    ProxyJump bastion.example.com\n\n")
+	}
+	buf.WriteString("Host *\n    ServerAliveInterval 30\n")
+	return buf.Bytes()
+}
+
+func BenchmarkLex(b *testing.B) {
+	in := string(generateConfig(4000))
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l := lexSSH(in)
+		for _, ok := l.nextToken(); ok; _, ok = l.nextToken() {
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	in := generateConfig(4000)
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeBytes(in); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	got := splitLines("a\r\nb\n\nc")
+	want := []sourceLine{
+		{text: "a", eol: "\r\n"},
+		{text: "b", eol: "\n"},
+		{text: "", eol: "\n"},
+		{text: "c", eol: ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitLines: got %+v, want %+v", got, want)
+	}
+	if got := splitLines(""); len(got) != 0 {
+		t.Errorf("splitLines(\"\"): got %+v, want no lines", got)
+	}
+}
@@ -1,6 +1,9 @@
 package ssh_config
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Position of a document element within a SSH document.
 //
@@ -23,3 +26,56 @@ func (p Position) String() string {
 func (p Position) Invalid() bool {
 	return p.Line <= 0 || p.Col <= 0
 }
+
+// sourceLine is a line of a config file as it was read. A node that was read
+// from a line prints the line unchanged, unless its fields have been changed
+// since.
+type sourceLine struct {
+	text string // the line, without the line terminator
+	eol  string // the line terminator: "\n", "\r\n", or "" at the end of the file
+	// fields is the value of the fields of the node when it was read.
+	fields string
+	// read is true if the node was read from this line.
+	read bool
+}
+
+// unchanged reports whether the node with the given current fields was read
+// from l and has not been changed since.
+func (l sourceLine) unchanged(fields string) bool {
+	return l.read && l.fields == fields
+}
+
+// lineEnding returns the terminator to print after the node read from l. A
+// node that was not read from a file is terminated by l.eol if it is set, or
+// by "\n".
+func (l sourceLine) lineEnding() string {
+	if !l.read && l.eol == "" {
+		return "\n"
+	}
+	return l.eol
+}
+
+// splitLines splits s into lines. A final line without a terminator is
+// included if it is not empty.
+func splitLines(s string) []sourceLine {
+	lines := make([]sourceLine, 0, strings.Count(s, "\n")+1)
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, sourceLine{text: s})
+			break
+		}
+		l := sourceLine{text: s[:i], eol: s[i : i+1]}
+		if strings.HasSuffix(l.text, "\r") {
+			l.text, l.eol = l.text[:i-1], "\r\n"
+		}
+		lines = append(lines, l)
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// indentOf returns the whitespace at the start of s.
+func indentOf(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
@@ -0,0 +1,392 @@
+package ssh_config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ResolvedConfig holds the effective value of every keyword for a single
+// host, similar to the output of "ssh -G". A ResolvedConfig is immutable; use
+// Resolve to create one.
+type ResolvedConfig struct {
+	// values maps lower-cased keywords to their effective values.
+	values map[string][]string
+	// the original host name and the local user it was resolved for
+	alias     string
+	localUser string
+	env       Environment
+}
+
+// Get returns the effective value for key, or the empty string if key has no
+// value. For keywords that can be specified multiple times, Get returns the
+// first value. The match for key is case insensitive.
+func (r *ResolvedConfig) Get(key string) string {
+	vals := r.values[strings.ToLower(key)]
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// GetAll returns all effective values for key, or nil if key has no value.
+// Only keywords for which SupportsMultiple returns true can have more than one
+// value. The match for key is case insensitive.
+func (r *ResolvedConfig) GetAll(key string) []string {
+	vals := r.values[strings.ToLower(key)]
+	if len(vals) == 0 {
+		return nil
+	}
+	return append([]string(nil), vals...)
+}
+
+// Keys returns the lower-cased keywords that have a value, in sorted order.
+func (r *ResolvedConfig) Keys() []string {
+	keys := make([]string, 0, len(r.values))
+	for k := range r.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Tokens returns the values of the percent tokens for the resolved host, for
+// use with ExpandTokens.
+func (r *ResolvedConfig) Tokens() *Tokens {
+	t := localTokens(r.localUser, r.environment())
+	t.Host = r.Get("HostName")
+	t.OriginalHost = r.alias
+	t.Port = r.Get("Port")
+	t.RemoteUser = r.Get("User")
+	if t.RemoteUser == "" {
+		t.RemoteUser = r.localUser
+	}
+	t.HostKeyAlias = r.Get("HostKeyAlias")
+	if t.HostKeyAlias == "" {
+		t.HostKeyAlias = r.alias
+	}
+	if proxyJump := r.Get("ProxyJump"); !isNone(proxyJump) {
+		t.ProxyJump = proxyJump
+	}
+	t.TunnelDevice = r.Get("TunnelDevice")
+	return t
+}
+
+// Expand is like Get, but expands the value the way ssh does (see Expand):
+// a leading "~", environment variables and the percent tokens accepted by key.
+func (r *ResolvedConfig) Expand(key string) (string, error) {
+	return Expand(key, r.Get(key), r.Tokens(), r.environment())
+}
+
+// ExpandAll is like GetAll, but expands the values the way ssh does (see
+// Expand).
+func (r *ResolvedConfig) ExpandAll(key string) ([]string, error) {
+	vals := r.GetAll(key)
+	if len(vals) == 0 {
+		return vals, nil
+	}
+	t := r.Tokens()
+	env := r.environment()
+	for i := range vals {
+		var err error
+		vals[i], err = Expand(key, vals[i], t, env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vals, nil
+}
+
+func (r *ResolvedConfig) environment() Environment {
+	if r.env == nil {
+		return osEnvironment{}
+	}
+	return r.env
+}
+
+// String prints r in the format used by "ssh -G": one "keyword value" line per
+// value, sorted by keyword.
+func (r *ResolvedConfig) String() string {
+	var buf strings.Builder
+	for _, k := range r.Keys() {
+		for _, v := range r.values[k] {
+			buf.WriteString(k)
+			buf.WriteByte(' ')
+			buf.WriteString(v)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String()
+}
+
+// resolver evaluates one or more configs for a single MatchContext and
+// collects the effective value of every keyword. As in OpenSSH, the first
+// value obtained for a keyword wins, except for keywords that support multiple
+// values, which accumulate.
+type resolver struct {
+	ctx    *MatchContext
+	values map[string][]string
+	// wantFinal is set if a "Match final" block was seen, which requests a
+	// final pass even if hostname canonicalization is disabled.
+	wantFinal bool
+}
+
+func newResolver(ctx *MatchContext) *resolver {
+	return &resolver{
+		ctx:    ctx,
+		values: make(map[string][]string),
+	}
+}
+
+// walk evaluates the blocks of c in order. If active is false, no block
+// matches, but "Match final" blocks are still noted, as OpenSSH does for files
+// included from blocks that do not match.
+func (r *resolver) walk(c *Config, active bool) error {
+	if c == nil {
+		return nil
+	}
+	for it := c.blocksFor(r.ctx, true); it.next(); {
+		block := c.Blocks[it.i]
+		if block.IsFinal() {
+			r.wantFinal = true
+		}
+		if err := r.block(block, active && block.Matches(r.ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) block(block Block, active bool) error {
+	for _, node := range block.GetNodes() {
+		switch t := node.(type) {
+		case *Empty:
+			continue
+		case *KV:
+			if active {
+				r.set(t.Key, t.Value)
+			}
+		case *Include:
+			if err := r.include(t, active); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown Node type %v", t)
+		}
+	}
+	return nil
+}
+
+func (r *resolver) include(inc *Include, active bool) error {
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+	for i := range inc.matches {
+		if err := r.walk(inc.files[inc.matches[i]], active); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) set(key, value string) {
+	lkey := strings.ToLower(key)
+	vals, ok := r.values[lkey]
+	if ok && (!SupportsMultiple(lkey) || slices.Contains(vals, value)) {
+		return
+	}
+	if !ok {
+		// Add values to context
+		switch lkey {
+		case "user":
+			r.ctx.User = value
+		case "hostname":
+			host, err := ExpandTokens(key, value, &Tokens{Host: r.ctx.OriginalHost})
+			if err != nil {
+				host = value
+			}
+			r.ctx.Host = host
+		case "port":
+			r.ctx.Port = value
+		case "tag":
+			r.ctx.Tag = value
+		case "hostkeyalias":
+			r.ctx.hostKeyAlias = value
+		case "proxyjump":
+			r.ctx.proxyJump = value
+		}
+	}
+	r.values[lkey] = append(vals, value)
+}
+
+// get returns the first value collected for key, or its default.
+func (r *resolver) get(key string) string {
+	if vals := r.values[strings.ToLower(key)]; len(vals) > 0 {
+		return vals[0]
+	}
+	return Default(key)
+}
+
+// finish applies modifiers and defaults to the collected values and validates
+// them.
+func (r *resolver) finish(alias, user string) (*ResolvedConfig, error) {
+	for lkey, vals := range r.values {
+		for i := range vals {
+			for _, mk := range modifiableKeys {
+				if strings.EqualFold(mk, lkey) {
+					vals[i] = handleModifiers(vals[i], mk)
+				}
+			}
+			if err := validate(lkey, vals[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for lkey, def := range defaults {
+		if _, ok := r.values[lkey]; !ok {
+			r.values[lkey] = []string{def}
+		}
+	}
+	if _, ok := r.values["identityfile"]; !ok {
+		r.values["identityfile"] = append([]string(nil), defaultProtocol2Identities...)
+	}
+	if err := r.expandHostName(alias); err != nil {
+		return nil, err
+	}
+	if _, ok := r.values["hostname"]; !ok {
+		r.values["hostname"] = []string{alias}
+	}
+	if _, ok := r.values["user"]; !ok && user != "" {
+		r.values["user"] = []string{user}
+	}
+	return &ResolvedConfig{values: r.values, alias: alias, localUser: r.ctx.LocalUser}, nil
+}
+
+// expandHostName expands the "%h" token in the HostName collected by r to
+// alias, as ssh does, and drops any later values for HostName.
+func (r *resolver) expandHostName(alias string) error {
+	vals, ok := r.values["hostname"]
+	if !ok {
+		return nil
+	}
+	host, err := ExpandTokens("HostName", vals[0], &Tokens{Host: alias})
+	if err != nil {
+		return err
+	}
+	r.values["hostname"] = []string{host}
+	return nil
+}
+
+// Resolve evaluates the configuration once for the given alias and returns the
+// effective value of every keyword, including defaults. See
+// UserSettings.Resolve for details.
+//
+// Resolve is a wrapper around DefaultUserSettings.Resolve.
+func Resolve(alias, user string) (*ResolvedConfig, error) {
+	return DefaultUserSettings.Resolve(alias, user)
+}
+
+// Resolve evaluates the custom, user and system configuration files once for
+// the given alias and returns the effective value of every keyword. Blocks are
+// matched in file order; the first value found for a keyword wins, except for
+// keywords that support multiple values (see SupportsMultiple), which are
+// collected across all matching blocks and files, ignoring duplicates.
+// Keywords without a value are set to their default.
+//
+// If CanonicalizeHostname is enabled, the host name is canonicalized using
+// u.HostResolver. If so, or if the configuration contains a "Match final"
+// block, the configuration is evaluated a second time, in which Host patterns
+// are matched against the final host name and "Match canonical" and "Match
+// final" blocks apply. Values found in the first pass take precedence.
+//
+// The returned error will be non-nil if a user's configuration file or the
+// system configuration file could not be parsed and u.IgnoreErrors is false,
+// or if any of the effective values is invalid.
+func (u *UserSettings) Resolve(alias, user string) (*ResolvedConfig, error) {
+	return u.ResolveContext(u.newMatchContext(alias, user))
+}
+
+// ResolveContext is like Resolve, but evaluates the configuration for the
+// alias and user of ctx. Facts that are not part of the configuration, such as
+// a Tag requested by the caller (like "ssh -P"), can be set on ctx before
+// calling ResolveContext. ctx is updated during evaluation. If ctx.Executor,
+// ctx.Interfaces or ctx.Environment are nil, the corresponding settings of u
+// are used.
+func (u *UserSettings) ResolveContext(ctx *MatchContext) (*ResolvedConfig, error) {
+	alias, user := ctx.OriginalHost, ctx.User
+	r, err := u.evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := r.finish(alias, user)
+	if err != nil {
+		return nil, err
+	}
+	rc.env = u.environment()
+	return rc, nil
+}
+
+// evaluate evaluates the custom, user and system configs for ctx the way
+// OpenSSH does. The configs are evaluated once; then, if hostname
+// canonicalization is enabled or a "Match final" block was seen, the host name
+// is canonicalized and the configs are evaluated again, with Host patterns
+// matched against the final host name and "Match canonical" and "Match final"
+// satisfied. Values found in the first pass take precedence.
+func (u *UserSettings) evaluate(ctx *MatchContext) (*resolver, error) {
+	u.doLoadConfigs()
+	//lint:ignore S1002 I prefer it this way
+	if u.onceErr != nil && u.IgnoreErrors == false {
+		return nil, u.onceErr
+	}
+
+	if ctx.Executor == nil {
+		ctx.Executor = u.Executor
+	}
+	if ctx.Interfaces == nil {
+		ctx.Interfaces = u.Interfaces
+	}
+	if ctx.Environment == nil {
+		ctx.Environment = u.environment()
+	}
+	r := newResolver(ctx)
+	if ctx.Tag != "" {
+		r.values["tag"] = []string{ctx.Tag}
+	}
+	configs := []*Config{u.customConfig, u.userConfig, u.systemConfig}
+	for _, c := range configs {
+		if err := r.walk(c, true); err != nil {
+			return nil, err
+		}
+	}
+
+	// Values of the keyword are case-insensitive, as in OpenSSH.
+	mode := strings.ToLower(r.get("CanonicalizeHostname"))
+	canonicalize := mode != "no"
+	if !canonicalize && !r.wantFinal {
+		return r, nil
+	}
+	host := ctx.Host
+	if net.ParseIP(host) == nil {
+		host = strings.ToLower(host)
+	}
+	if canonicalize {
+		res := u.HostResolver
+		if res == nil {
+			res = netResolver{}
+		}
+		var err error
+		if host, err = r.canonicalize(host, mode, res); err != nil {
+			return nil, err
+		}
+	}
+	ctx.Host = host
+	ctx.FinalPass = true
+	r.values["hostname"] = []string{host}
+	for _, c := range configs {
+		if err := r.walk(c, true); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,196 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/config1"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	rc, err := us.Resolve("wap", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"User", "root"},
+		{"kexalgorithms", "diffie-hellman-group1-sha1"},
+		{"HostKeyAlgorithms", "ssh-ed25519,ssh-rsa"},
+		{"HostName", "wap"},
+		{"Port", "22"},
+		{"CanonicalDomains", ""},
+	}
+	for _, tt := range tests {
+		if got := rc.Get(tt.key); got != tt.want {
+			t.Errorf("Get(%q): got %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	if got := rc.GetAll("CanonicalDomains"); got != nil {
+		t.Errorf("GetAll(CanonicalDomains): got %q, want nil", got)
+	}
+}
+
+func TestResolveMultiple(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/identities"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	rc, err := us.Resolve("has2identity", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.GetAll("IdentityFile"); !reflect.DeepEqual(got, []string{"f1", "f2"}) {
+		t.Errorf(`expected ["f1", "f2"], got %q`, got)
+	}
+
+	rc, err = us.Resolve("randomhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.GetAll("IdentityFile"); !reflect.DeepEqual(got, defaultProtocol2Identities) {
+		t.Errorf("expected default protocol 2 identities %v, got %v", defaultProtocol2Identities, got)
+	}
+}
+
+func TestResolveMatch(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-directive"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	rc, err := us.Resolve("testhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rc.Get("Port"); got != "4567" {
+		t.Errorf("expected Port to be %q, got %q", "4567", got)
+	}
+	if got := rc.Get("HostName"); got != "hostname" {
+		t.Errorf("expected HostName to be %q, got %q", "hostname", got)
+	}
+	if got := rc.Get("User"); got != "testuser" {
+		t.Errorf("expected User to be %q, got %q", "testuser", got)
+	}
+}
+
+func TestResolveMatchFinal(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/match-final"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	for alias, want := range map[string]string{
+		"testhost":  "4567",
+		"testhost2": "1234",
+		"testhost3": "3333",
+	} {
+		rc, err := us.Resolve(alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rc.Get("Port"); got != want {
+			t.Errorf("%s: expected Port to be %q, got %q", alias, want, got)
+		}
+	}
+}
+
+func TestGetStrictHostName(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(name, []byte("Host *.short\n  HostName %h.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder(name),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	rc, err := us.Resolve("db.short", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "db.short.example.com"
+	if got := rc.Get("HostName"); got != want {
+		t.Errorf("Resolve: expected HostName to be %q, got %q", want, got)
+	}
+	got, err := us.GetStrict("db.short", "HostName", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("GetStrict: expected HostName to be %q, got %q", want, got)
+	}
+	all, err := us.GetAllStrict("db.short", "HostName", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(all, []string{want}) {
+		t.Errorf("GetAllStrict: expected HostName to be [%q], got %q", want, all)
+	}
+}
+
+func TestResolveModifiers(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/modifiers"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	rc, err := us.Resolve("plus", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Default("Ciphers") + ",a,b"; rc.Get("Ciphers") != want {
+		t.Errorf("expected Ciphers to be %q, got %q", want, rc.Get("Ciphers"))
+	}
+}
+
+func TestResolveInvalid(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/invalid-port"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	_, err := us.Resolve("test.test", "")
+	if err == nil {
+		t.Fatal("expected non-nil err, got nil")
+	}
+	if err.Error() != `ssh_config: strconv.ParseUint: parsing "notanumber": invalid syntax` {
+		t.Errorf("wrong error: got %v", err)
+	}
+}
+
+func TestResolvedConfigString(t *testing.T) {
+	us := &UserSettings{
+		userConfigFinder:   testConfigFinder("testdata/identities"),
+		systemConfigFinder: nullConfigFinder,
+	}
+
+	rc, err := us.Resolve("has2identity", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := rc.String()
+	for _, line := range []string{"identityfile f1\nidentityfile f2\n", "user root\n", "port 22\n", "hostname has2identity\n"} {
+		if !strings.Contains(s, line) {
+			t.Errorf("expected output to contain %q, got:\n%s", line, s)
+		}
+	}
+}
+
+func TestSupportsMultiple(t *testing.T) {
+	if !SupportsMultiple("IdentityFile") || !SupportsMultiple("identityfile") {
+		t.Error("expected IdentityFile to support multiple values")
+	}
+	if SupportsMultiple("Port") {
+		t.Error("expected Port not to support multiple values")
+	}
+}
@@ -0,0 +1,221 @@
+package ssh_config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Filename returns the path of the file c was read from, or "" if c was not
+// read from a file on the local file system, e.g. by DecodeBytes or DecodeFS.
+func (c *Config) Filename() string {
+	return c.path
+}
+
+// Configs returns the configs of the files included by inc, in the order they
+// are evaluated. A file that is included more than once is listed once.
+func (inc *Include) Configs() []*Config {
+	inc.mu.Lock()
+	defer inc.mu.Unlock()
+	configs := make([]*Config, 0, len(inc.files))
+	seen := make(map[string]bool, len(inc.files))
+	for _, match := range inc.matches {
+		if !seen[match] {
+			seen[match] = true
+			configs = append(configs, inc.files[match])
+		}
+	}
+	return configs
+}
+
+// Owner returns the config that b belongs to: c, or the config of a file
+// included by c, directly or indirectly. Use it to find the config to call
+// RemoveBlock or MoveBlock on for a block of an included file. Owner
+// returns nil if b is in none of them.
+func (c *Config) Owner(b Block) *Config {
+	var owner *Config
+	c.walk(func(cfg *Config) bool {
+		for _, block := range cfg.Blocks {
+			if block == b {
+				owner = cfg
+				return false
+			}
+		}
+		return true
+	})
+	return owner
+}
+
+// walk calls f for c and the configs of the files it includes, depth first
+// and in order, until f returns false. It reports whether f returned true
+// for all of them.
+func (c *Config) walk(f func(*Config) bool) bool {
+	if !f(c) {
+		return false
+	}
+	for _, block := range c.Blocks {
+		for _, node := range block.GetNodes() {
+			inc, ok := node.(*Include)
+			if !ok {
+				continue
+			}
+			for _, cfg := range inc.Configs() {
+				if !cfg.walk(f) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// SaveOptions control how SaveAll writes files.
+type SaveOptions struct {
+	// BackupSuffix, if not empty, makes SaveAll keep the previous contents
+	// of each file it writes in a file with the same name followed by the
+	// suffix, e.g. ".bak".
+	BackupSuffix string
+}
+
+// ErrModifiedOnDisk is returned by SaveAll if a file has changed since it was
+// read.
+var ErrModifiedOnDisk = errors.New("ssh_config: file changed since it was read")
+
+// SaveAll writes c and the files it includes back to the files they were
+// read from, and returns the names of the files it wrote. Only files whose
+// contents have changed are written, so that edits to a block in an included
+// file are saved to that file and all other files are left alone.
+//
+// Each file is written atomically, by writing a temporary file in the same
+// directory and renaming it over the file, which keeps the mode and, where
+// the platform supports it, the owner and group of the file. Symbolic links
+// are followed, so the file they point to is replaced rather than the link.
+//
+// SaveAll returns an error without writing a file if the file has changed
+// on disk since it was read, with ErrModifiedOnDisk as the cause, or if a
+// changed config was not read from a file on the local file system. Files
+// written before an error are not restored.
+func (c *Config) SaveAll(opts SaveOptions) ([]string, error) {
+	type file struct {
+		configs []*Config
+		data    []byte
+	}
+	var files []*file
+	byPath := make(map[string]*file)
+	var err error
+	c.walk(func(cfg *Config) bool {
+		data := marshal(*cfg).Bytes()
+		if sha256.Sum256(data) == cfg.sum {
+			return true
+		}
+		if cfg.path == "" {
+			err = errors.New("ssh_config: cannot save a config that was not read from a local file")
+			return false
+		}
+		f := byPath[cfg.path]
+		if f == nil {
+			f = &file{data: data}
+			byPath[cfg.path] = f
+			files = append(files, f)
+		} else if !bytes.Equal(f.data, data) {
+			// The file is included more than once, and the copies
+			// were edited differently.
+			err = fmt.Errorf("ssh_config: conflicting changes to %s", cfg.path)
+			return false
+		}
+		f.configs = append(f.configs, cfg)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, f := range files {
+		cfg := f.configs[0]
+		if err := writeConfigFile(cfg.path, cfg.sum, f.data, opts.BackupSuffix); err != nil {
+			return written, err
+		}
+		sum := sha256.Sum256(f.data)
+		for _, cfg := range f.configs {
+			cfg.sum = sum
+		}
+		written = append(written, cfg.path)
+	}
+	return written, nil
+}
+
+// writeConfigFile replaces the contents of the file at path, whose hash must
+// still be old, with data.
+func writeConfigFile(path string, old [sha256.Size]byte, data []byte, backupSuffix string) error {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(target)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(current) != old {
+		return &os.PathError{Op: "save", Path: path, Err: ErrModifiedOnDisk}
+	}
+	if backupSuffix != "" {
+		if err := writeFileAtomic(target+backupSuffix, current, fi); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(target, data, fi)
+}
+
+// WriteFile replaces the contents of the named file with data, the way SaveAll
+// writes files: atomically, keeping the mode and, where the platform supports
+// it, the owner and group of the file, and following symbolic links. The file
+// must exist.
+func WriteFile(name string, data []byte) error {
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(target, data, fi)
+}
+
+// writeFileAtomic writes data to a temporary file next to name, with the
+// mode, owner and group described by fi, and renames it to name.
+func writeFileAtomic(name string, data []byte, fi os.FileInfo) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := chown(f, fi); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
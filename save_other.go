@@ -0,0 +1,12 @@
+//go:build !unix
+
+package ssh_config
+
+import (
+	"os"
+)
+
+// chown does nothing on platforms without Unix file ownership.
+func chown(f *os.File, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,180 @@
+package ssh_config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveAll(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config":           "Include config.d/*.conf\n\nHost *\n  User all\n",
+		"config.d/a.conf":  "Host a\n  User a\n",
+		"config.d/b.conf":  "# bastion\nHost bastion\n\tUser ops\n",
+		"dotfiles/c.conf":  "Host c\n  User c\n",
+		"config.d/c.conf":  "",
+		"config.d/ignored": "",
+	})
+	link := filepath.Join(dir, "config.d/c.conf")
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", "dotfiles", "c.conf"), link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	b := filepath.Join(dir, "config.d/b.conf")
+	if err := os.Chmod(b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	before := map[string]os.FileInfo{}
+	for _, name := range []string{"config", "config.d/a.conf"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before[name] = fi
+	}
+
+	cfg, err := DecodeOptions{BaseDir: dir}.DecodeFile(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, err := cfg.SaveAll(SaveOptions{}); err != nil || len(files) != 0 {
+		t.Fatalf("SaveAll without changes: got %q, %v", files, err)
+	}
+	inc := cfg.Blocks[0].GetNodes()[0].(*Include)
+	configs := inc.Configs()
+	if len(configs) != 3 {
+		t.Fatalf("got %d included configs, want 3", len(configs))
+	}
+	bastion := configs[1].Blocks[1].(*Host)
+	if owner := cfg.Owner(bastion); owner != configs[1] {
+		t.Fatalf("Owner: got %v, want the config of b.conf", owner)
+	}
+	if got, want := configs[1].Filename(), b; got != want {
+		t.Errorf("Filename: got %q, want %q", got, want)
+	}
+	if err := bastion.Set("HostName", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configs[2].Blocks[1].(*Host).Set("User", "cc"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := cfg.SaveAll(SaveOptions{BackupSuffix: ".bak"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{b, link}; !reflect.DeepEqual(files, want) {
+		t.Errorf("SaveAll: wrote %q, want %q", files, want)
+	}
+	for name, want := range map[string]string{
+		"config.d/b.conf":     "# bastion\nHost bastion\n\tUser ops\n\tHostName 10.0.0.1\n",
+		"config.d/b.conf.bak": "# bastion\nHost bastion\n\tUser ops\n",
+		"dotfiles/c.conf":     "Host c\n  User cc\n",
+		"dotfiles/c.conf.bak": "Host c\n  User c\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is no longer a symbolic link", link)
+	}
+	if fi, err := os.Stat(b); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("mode of %s: got %v, want 0600", b, fi.Mode())
+	}
+	for name, fi := range before {
+		after, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(fi, after) || !after.ModTime().Equal(fi.ModTime()) {
+			t.Errorf("%s was written", name)
+		}
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*", ".*.tmp*"))
+	if err != nil || len(matches) != 0 {
+		t.Errorf("temporary files left behind: %q", matches)
+	}
+
+	// A second save writes nothing.
+	if files, err := cfg.SaveAll(SaveOptions{}); err != nil || len(files) != 0 {
+		t.Errorf("second SaveAll: got %q, %v", files, err)
+	}
+}
+
+func TestSaveAllModifiedOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"config": "Host a\n  User a\n"})
+	path := filepath.Join(dir, "config")
+	cfg, err := DecodeOptions{}.DecodeFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Blocks[1].(*Host).Set("User", "b"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"config": "Host a\n  User c\n"})
+	if _, err := cfg.SaveAll(SaveOptions{}); !errors.Is(err, ErrModifiedOnDisk) {
+		t.Fatalf("SaveAll: got %v, want %v", err, ErrModifiedOnDisk)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Host a\n  User c\n" {
+		t.Errorf("file was overwritten: %q", got)
+	}
+}
+
+func TestSaveAllNotFromFile(t *testing.T) {
+	cfg, err := DecodeBytes([]byte("Host a\n  User a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, err := cfg.SaveAll(SaveOptions{}); err != nil || len(files) != 0 {
+		t.Errorf("SaveAll without changes: got %q, %v", files, err)
+	}
+	if err := cfg.Blocks[1].(*Host).Set("User", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.SaveAll(SaveOptions{}); err == nil {
+		t.Error("SaveAll: expected an error")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"dotfiles/config": "Host a\n"})
+	target := filepath.Join(dir, "dotfiles", "config")
+	if err := os.Chmod(target, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "config")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip(err)
+	}
+	if err := WriteFile(link, []byte("Host b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != "Host b\n" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s is no longer a symbolic link", link)
+	}
+	if fi, err := os.Stat(target); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("mode of %s: got %v, want 0600", target, fi.Mode())
+	}
+	if err := WriteFile(filepath.Join(dir, "missing"), nil); err == nil {
+		t.Error("WriteFile of a missing file: expected an error")
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package ssh_config
+
+import (
+	"os"
+	"syscall"
+)
+
+// chown gives f the owner and group described by fi, if they differ from
+// those of f.
+func chown(f *os.File, fi os.FileInfo) error {
+	want, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	have, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st, ok := have.Sys().(*syscall.Stat_t); ok && st.Uid == want.Uid && st.Gid == want.Gid {
+		return nil
+	}
+	return f.Chown(int(want.Uid), int(want.Gid))
+}
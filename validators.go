@@ -172,15 +172,148 @@ var defaultProtocol2Identities = []string{
 // these directives support multiple items that can be collected
 // across multiple files
 var pluralDirectives = map[string]bool{
-	"CertificateFile": true,
-	"IdentityFile":    true,
-	"DynamicForward":  true,
-	"RemoteForward":   true,
-	"SendEnv":         true,
-	"SetEnv":          true,
+	strings.ToLower("CertificateFile"): true,
+	strings.ToLower("IdentityFile"):    true,
+	strings.ToLower("DynamicForward"):  true,
+	strings.ToLower("LocalForward"):    true,
+	strings.ToLower("RemoteForward"):   true,
+	strings.ToLower("SendEnv"):         true,
+	strings.ToLower("SetEnv"):          true,
 }
 
 // SupportsMultiple reports whether a directive can be specified multiple times.
 func SupportsMultiple(key string) bool {
 	return pluralDirectives[strings.ToLower(key)]
 }
+
+// knownKeywords maps the keywords that ssh accepts, in lower case, to their
+// spelling in the documentation of ssh_config.
+var knownKeywords = map[string]string{
+	strings.ToLower("AddKeysToAgent"):                   "AddKeysToAgent",
+	strings.ToLower("AddressFamily"):                    "AddressFamily",
+	strings.ToLower("BatchMode"):                        "BatchMode",
+	strings.ToLower("BindAddress"):                      "BindAddress",
+	strings.ToLower("BindInterface"):                    "BindInterface",
+	strings.ToLower("CanonicalDomains"):                 "CanonicalDomains",
+	strings.ToLower("CanonicalizeFallbackLocal"):        "CanonicalizeFallbackLocal",
+	strings.ToLower("CanonicalizeHostname"):             "CanonicalizeHostname",
+	strings.ToLower("CanonicalizeMaxDots"):              "CanonicalizeMaxDots",
+	strings.ToLower("CanonicalizePermittedCNAMEs"):      "CanonicalizePermittedCNAMEs",
+	strings.ToLower("CASignatureAlgorithms"):            "CASignatureAlgorithms",
+	strings.ToLower("CertificateFile"):                  "CertificateFile",
+	strings.ToLower("ChannelTimeout"):                   "ChannelTimeout",
+	strings.ToLower("CheckHostIP"):                      "CheckHostIP",
+	strings.ToLower("Ciphers"):                          "Ciphers",
+	strings.ToLower("ClearAllForwardings"):              "ClearAllForwardings",
+	strings.ToLower("Compression"):                      "Compression",
+	strings.ToLower("ConnectionAttempts"):               "ConnectionAttempts",
+	strings.ToLower("ConnectTimeout"):                   "ConnectTimeout",
+	strings.ToLower("ControlMaster"):                    "ControlMaster",
+	strings.ToLower("ControlPath"):                      "ControlPath",
+	strings.ToLower("ControlPersist"):                   "ControlPersist",
+	strings.ToLower("DynamicForward"):                   "DynamicForward",
+	strings.ToLower("EnableEscapeCommandline"):          "EnableEscapeCommandline",
+	strings.ToLower("EnableSSHKeysign"):                 "EnableSSHKeysign",
+	strings.ToLower("EscapeChar"):                       "EscapeChar",
+	strings.ToLower("ExitOnForwardFailure"):             "ExitOnForwardFailure",
+	strings.ToLower("FingerprintHash"):                  "FingerprintHash",
+	strings.ToLower("ForkAfterAuthentication"):          "ForkAfterAuthentication",
+	strings.ToLower("ForwardAgent"):                     "ForwardAgent",
+	strings.ToLower("ForwardX11"):                       "ForwardX11",
+	strings.ToLower("ForwardX11Timeout"):                "ForwardX11Timeout",
+	strings.ToLower("ForwardX11Trusted"):                "ForwardX11Trusted",
+	strings.ToLower("GatewayPorts"):                     "GatewayPorts",
+	strings.ToLower("GlobalKnownHostsFile"):             "GlobalKnownHostsFile",
+	strings.ToLower("GSSAPIAuthentication"):             "GSSAPIAuthentication",
+	strings.ToLower("GSSAPIDelegateCredentials"):        "GSSAPIDelegateCredentials",
+	strings.ToLower("HashKnownHosts"):                   "HashKnownHosts",
+	strings.ToLower("Host"):                             "Host",
+	strings.ToLower("HostbasedAcceptedAlgorithms"):      "HostbasedAcceptedAlgorithms",
+	strings.ToLower("HostbasedAuthentication"):          "HostbasedAuthentication",
+	strings.ToLower("HostbasedKeyTypes"):                "HostbasedKeyTypes",
+	strings.ToLower("HostKeyAlgorithms"):                "HostKeyAlgorithms",
+	strings.ToLower("HostKeyAlias"):                     "HostKeyAlias",
+	strings.ToLower("HostName"):                         "HostName",
+	strings.ToLower("IdentitiesOnly"):                   "IdentitiesOnly",
+	strings.ToLower("IdentityAgent"):                    "IdentityAgent",
+	strings.ToLower("IdentityFile"):                     "IdentityFile",
+	strings.ToLower("IgnoreUnknown"):                    "IgnoreUnknown",
+	strings.ToLower("Include"):                          "Include",
+	strings.ToLower("IPQoS"):                            "IPQoS",
+	strings.ToLower("KbdInteractiveAuthentication"):     "KbdInteractiveAuthentication",
+	strings.ToLower("KbdInteractiveDevices"):            "KbdInteractiveDevices",
+	strings.ToLower("KexAlgorithms"):                    "KexAlgorithms",
+	strings.ToLower("KnownHostsCommand"):                "KnownHostsCommand",
+	strings.ToLower("LocalCommand"):                     "LocalCommand",
+	strings.ToLower("LocalForward"):                     "LocalForward",
+	strings.ToLower("LogLevel"):                         "LogLevel",
+	strings.ToLower("LogVerbose"):                       "LogVerbose",
+	strings.ToLower("MACs"):                             "MACs",
+	strings.ToLower("Match"):                            "Match",
+	strings.ToLower("NoHostAuthenticationForLocalhost"): "NoHostAuthenticationForLocalhost",
+	strings.ToLower("NumberOfPasswordPrompts"):          "NumberOfPasswordPrompts",
+	strings.ToLower("ObscureKeystrokeTiming"):           "ObscureKeystrokeTiming",
+	strings.ToLower("PasswordAuthentication"):           "PasswordAuthentication",
+	strings.ToLower("PermitLocalCommand"):               "PermitLocalCommand",
+	strings.ToLower("PermitRemoteOpen"):                 "PermitRemoteOpen",
+	strings.ToLower("PKCS11Provider"):                   "PKCS11Provider",
+	strings.ToLower("Port"):                             "Port",
+	strings.ToLower("PreferredAuthentications"):         "PreferredAuthentications",
+	strings.ToLower("ProxyCommand"):                     "ProxyCommand",
+	strings.ToLower("ProxyJump"):                        "ProxyJump",
+	strings.ToLower("ProxyUseFdpass"):                   "ProxyUseFdpass",
+	strings.ToLower("PubkeyAcceptedAlgorithms"):         "PubkeyAcceptedAlgorithms",
+	strings.ToLower("PubkeyAcceptedKeyTypes"):           "PubkeyAcceptedKeyTypes",
+	strings.ToLower("PubkeyAuthentication"):             "PubkeyAuthentication",
+	strings.ToLower("RefuseConnection"):                 "RefuseConnection",
+	strings.ToLower("RekeyLimit"):                       "RekeyLimit",
+	strings.ToLower("RemoteCommand"):                    "RemoteCommand",
+	strings.ToLower("RemoteForward"):                    "RemoteForward",
+	strings.ToLower("RequestTTY"):                       "RequestTTY",
+	strings.ToLower("RequiredRSASize"):                  "RequiredRSASize",
+	strings.ToLower("RevokedHostKeys"):                  "RevokedHostKeys",
+	strings.ToLower("SecurityKeyProvider"):              "SecurityKeyProvider",
+	strings.ToLower("SendEnv"):                          "SendEnv",
+	strings.ToLower("ServerAliveCountMax"):              "ServerAliveCountMax",
+	strings.ToLower("ServerAliveInterval"):              "ServerAliveInterval",
+	strings.ToLower("SessionType"):                      "SessionType",
+	strings.ToLower("SetEnv"):                           "SetEnv",
+	strings.ToLower("StdinNull"):                        "StdinNull",
+	strings.ToLower("StreamLocalBindMask"):              "StreamLocalBindMask",
+	strings.ToLower("StreamLocalBindUnlink"):            "StreamLocalBindUnlink",
+	strings.ToLower("StrictHostKeyChecking"):            "StrictHostKeyChecking",
+	strings.ToLower("SyslogFacility"):                   "SyslogFacility",
+	strings.ToLower("Tag"):                              "Tag",
+	strings.ToLower("TCPKeepAlive"):                     "TCPKeepAlive",
+	strings.ToLower("Tunnel"):                           "Tunnel",
+	strings.ToLower("TunnelDevice"):                     "TunnelDevice",
+	strings.ToLower("UpdateHostKeys"):                   "UpdateHostKeys",
+	strings.ToLower("User"):                             "User",
+	strings.ToLower("UserKnownHostsFile"):               "UserKnownHostsFile",
+	strings.ToLower("VerifyHostKeyDNS"):                 "VerifyHostKeyDNS",
+	strings.ToLower("VisualHostKey"):                    "VisualHostKey",
+	strings.ToLower("WarnWeakCrypto"):                   "WarnWeakCrypto",
+	strings.ToLower("XAuthLocation"):                    "XAuthLocation",
+
+	// deprecated or unsupported keywords, which ssh ignores
+	strings.ToLower("ChallengeResponseAuthentication"): "ChallengeResponseAuthentication",
+	strings.ToLower("Cipher"):                          "Cipher",
+	strings.ToLower("CompressionLevel"):                "CompressionLevel",
+	strings.ToLower("DSAAuthentication"):               "DSAAuthentication",
+	strings.ToLower("FallBackToRsh"):                   "FallBackToRsh",
+	strings.ToLower("GSSAPIClientIdentity"):            "GSSAPIClientIdentity",
+	strings.ToLower("GSSAPIKeyExchange"):               "GSSAPIKeyExchange",
+	strings.ToLower("GSSAPIRenewalForcesRekey"):        "GSSAPIRenewalForcesRekey",
+	strings.ToLower("GSSAPIServerIdentity"):            "GSSAPIServerIdentity",
+	strings.ToLower("GSSAPITrustDns"):                  "GSSAPITrustDns",
+	strings.ToLower("KeepAlive"):                       "KeepAlive",
+	strings.ToLower("Protocol"):                        "Protocol",
+	strings.ToLower("RhostsAuthentication"):            "RhostsAuthentication",
+	strings.ToLower("RhostsRSAAuthentication"):         "RhostsRSAAuthentication",
+	strings.ToLower("RSAAuthentication"):               "RSAAuthentication",
+	strings.ToLower("SmartcardDevice"):                 "SmartcardDevice",
+	strings.ToLower("UseBlacklistedKeys"):              "UseBlacklistedKeys",
+	strings.ToLower("UsePrivilegedPort"):               "UsePrivilegedPort",
+	strings.ToLower("UseRoaming"):                      "UseRoaming",
+	strings.ToLower("UseRsh"):                          "UseRsh",
+}